@@ -0,0 +1,256 @@
+package main
+
+import (
+	"log"
+	"math"
+	"math/cmplx"
+
+	"github.com/krig/Go-SDL2/sdl"
+)
+
+const (
+	// how much of each track is compared, from where its clips start,
+	// in seconds
+	ALIGN_SECONDS = 60
+	// the rate the tracks are first compared at, before the match is
+	// refined at the full rate
+	ALIGN_RATE = 1000
+	// how far a nudge moves a track, in seconds, and with shift held
+	ALIGN_NUDGE = 0.01
+	ALIGN_NUDGE_FINE = 0.001
+)
+
+// alignment is where a track was found to line up with the reference
+type alignment struct {
+	node *Node
+	// the clips the alignment was worked out for
+	key string
+	// how far to move the clips, in seconds
+	delta float64
+	// the correlation at the match, from 0 to 1
+	confidence float64
+}
+
+// snapshot copies the clips of an input, to be read away from the UI
+func (node *Node) snapshot() *track {
+	return &track{node.clipsKey(), node.format, append([]Clip(nil), node.clips...)}
+}
+
+// firstStart returns where the first clip of a track starts
+func firstStart(clips []Clip) float64 {
+	start := math.Inf(1)
+	for _, c := range clips {
+		start = math.Min(start, c.Start)
+	}
+	return start
+}
+
+// readMono mixes the clips of a track from a time on the timeline
+// down to mono, for as many seconds as asked
+func readMono(t *track, from, seconds float64) []float32 {
+	start, frames := int64(from * t.format.Rate), int64(seconds * t.format.Rate)
+	var readers []*clipReader
+	for _, c := range crossfades(t.clips) {
+		if r := openClip(c, t.format); r != nil {
+			readers = append(readers, r)
+			defer r.Release()
+		}
+	}
+	out := make([]float32, frames)
+	b := NewBlock(t.format)
+	for at := int64(0); at < frames; at += BLOCK_SIZE {
+		n := frames - at
+		if n > BLOCK_SIZE {
+			n = BLOCK_SIZE
+		}
+		b.Clear(int(n))
+		for _, r := range readers {
+			r.mix(b, start + at)
+		}
+		data := b.Samples()
+		for f := int64(0); f < n; f++ {
+			for c := 0; c < b.Channels; c++ {
+				out[at + f] += data[f * int64(b.Channels) + int64(c)] / float32(b.Channels)
+			}
+		}
+	}
+	return out
+}
+
+// envelope averages the level of x over every factor samples, less
+// its mean, so claps and speech line up whatever their phase
+func envelope(x []float32, factor int) []float64 {
+	env := make([]float64, len(x) / factor)
+	mean := 0.0
+	for i := range env {
+		for _, s := range x[i * factor:(i + 1) * factor] {
+			env[i] += math.Abs(float64(s))
+		}
+		env[i] /= float64(factor)
+		mean += env[i]
+	}
+	mean /= math.Max(1, float64(len(env)))
+	for i := range env {
+		env[i] -= mean
+	}
+	return env
+}
+
+// correlate returns the lag k where a[i + k] best matches b[i]
+func correlate(a, b []float64) int {
+	size := fftSize(len(a) + len(b))
+	fa, fb := make([]complex128, size), make([]complex128, size)
+	for i, s := range a {
+		fa[i] = complex(s, 0)
+	}
+	for i, s := range b {
+		fb[i] = complex(s, 0)
+	}
+	fft(fa, false)
+	fft(fb, false)
+	for i := range fa {
+		fa[i] *= cmplx.Conj(fb[i])
+	}
+	fft(fa, true)
+	best, lag := math.Inf(-1), 0
+	for i, c := range fa {
+		k := i
+		if i >= size - len(b) {
+			k = i - size
+		}
+		if v := math.Abs(real(c)); v > best {
+			best, lag = v, k
+		}
+	}
+	return lag
+}
+
+// match returns the normalised correlation of a[i + k] and b[i] where
+// they overlap
+func match(a, b []float32, k int) float64 {
+	var ab, aa, bb float64
+	i := 0
+	if k < 0 {
+		i = -k
+	}
+	for ; i < len(b) && i + k < len(a); i++ {
+		x, y := float64(a[i + k]), float64(b[i])
+		ab += x * y
+		aa += x * x
+		bb += y * y
+	}
+	if aa == 0 || bb == 0 {
+		return 0
+	}
+	return math.Abs(ab) / math.Sqrt(aa * bb)
+}
+
+// lag returns the k where a[i + k] best matches b[i], and how well.
+// The level envelopes are matched first, then the match is refined
+// sample by sample around it.
+func lag(a, b []float32, rate float64) (int, float64) {
+	factor := int(math.Max(1, rate / ALIGN_RATE))
+	coarse := correlate(envelope(a, factor), envelope(b, factor)) * factor
+	k, best := coarse, -1.0
+	for i := coarse - 2 * factor; i <= coarse + 2 * factor; i++ {
+		if c := match(a, b, i); c > best {
+			k, best = i, c
+		}
+	}
+	return k, best
+}
+
+// align finds how far to move a track to line it up with the reference
+func align(ref, t *track) (float64, float64) {
+	rs, ts := firstStart(ref.clips), firstStart(t.clips)
+	k, confidence := lag(readMono(ref, rs, ALIGN_SECONDS), readMono(t, ts, ALIGN_SECONDS), t.format.Rate)
+	return rs + float64(k) / t.format.Rate - ts, confidence
+}
+
+// shiftClips moves clips along the timeline, trimming off whatever
+// would end up before the start
+func shiftClips(clips []Clip, delta float64) []Clip {
+	var out []Clip
+	for _, c := range clips {
+		c.Start += delta
+		if c.End() <= MIN_CLIP {
+			continue
+		}
+		if c.Start < 0 {
+			c.TrimStart(0)
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// SetReference makes an input the track others are aligned to
+func (canvas *CanvasPane) SetReference(n *Node) {
+	canvas.reference = n
+	log.Println("Aligning to", n.label.Text)
+}
+
+// AlignToReference lines an input up with the reference track in the
+// background, moving its clips when done
+func (canvas *CanvasPane) AlignToReference(n *Node) {
+	ref := canvas.reference
+	switch {
+	case ref == nil:
+		log.Println("Pick a reference track first.")
+		return
+	case ref == n:
+		return
+	case len(ref.clips) == 0 || len(n.clips) == 0:
+		log.Println("Nothing to align.")
+		return
+	case ref.format.Rate != n.format.Rate:
+		log.Println("Can't align tracks at different sample rates.")
+		return
+	}
+	r, t := ref.snapshot(), n.snapshot()
+	log.Println("Aligning", n.label.Text, "to", ref.label.Text)
+	go func() {
+		delta, confidence := align(r, t)
+		canvas.alignments <- alignment{n, t.key, delta, confidence}
+	}()
+}
+
+// UpdateAlignments moves the clips of tracks that have been aligned,
+// unless they were edited in the meantime
+func (canvas *CanvasPane) UpdateAlignments() {
+	for {
+		select {
+		case a := <-canvas.alignments:
+			if a.node.clipsKey() != a.key {
+				log.Println(a.node.label.Text, "changed while aligning, try again.")
+				continue
+			}
+			a.node.clips = shiftClips(a.node.clips, a.delta)
+			a.node.aligned = a.confidence
+			log.Printf("Moved %s %+.3fs, %.0f%% confident\n", a.node.label.Text, a.delta, a.confidence * 100)
+		default:
+			return
+		}
+	}
+}
+
+// Nudge moves the clips of an input a little, to fix up an alignment
+func (canvas *CanvasPane) Nudge(n *Node, delta float64) {
+	if n.name != "input" || len(n.clips) == 0 {
+		return
+	}
+	n.clips = shiftClips(n.clips, delta)
+	log.Printf("Nudged %s %+.0fms\n", n.label.Text, delta * 1000)
+}
+
+// nudgeStep returns how far the arrow keys nudge, finer with shift held
+func nudgeStep(keycode sdl.Keycode) float64 {
+	step := ALIGN_NUDGE
+	if (sdl.GetModState() & sdl.KMOD_SHIFT) != 0 {
+		step = ALIGN_NUDGE_FINE
+	}
+	if keycode == sdl.K_LEFT {
+		return -step
+	}
+	return step
+}
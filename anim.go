@@ -10,8 +10,8 @@ import (
 // Position animation, color animation...?
 
 func interp(current, target, dt float64) float64 {
-	if target - current < 0.00001 {
-		target = current
+	if math.Abs(target - current) < 0.00001 || dt >= 1 {
+		return target
 	}
 	return current + (target - current) * dt
 }
@@ -26,7 +26,7 @@ func makeFloatColor(clr sdl.Color) FloatColor {
 }
 
 func makeSDLColor(clr FloatColor) sdl.Color {
-	return sdl.Color{uint8(math.Floor(clr.R + 0.5)), uint8(math.Floor(clr.G + 0.5)), uint8(math.Floor(clr.B + 0.5)), uint8(math.Floor(clr.A + 0.5))}
+	return sdl.Color{uint8(math.Floor(clr.R * 255.0 + 0.5)), uint8(math.Floor(clr.G * 255.0 + 0.5)), uint8(math.Floor(clr.B * 255.0 + 0.5)), uint8(math.Floor(clr.A * 255.0 + 0.5))}
 }
 
 // ColorAnimation animates a color
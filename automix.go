@@ -0,0 +1,110 @@
+package main
+
+import (
+	"math"
+)
+
+// an automix node mixes speakers like a mixer, sharing the gain
+// between them so only whoever is talking is up
+var automixParams = []ParamSpec{
+	{"attenuation", UNIT_DB, -40, 0, -15, false},
+	{"threshold", UNIT_DB, -70, -20, -50, false},
+	{"hold", UNIT_MS, 0, 2000, 300, false},
+	{"release", UNIT_MS, 10, 2000, 150, true},
+}
+
+// how fast the level of a speaker is followed, and how fast a gain
+// opens up, in milliseconds
+const (
+	AUTOMIX_LEVEL_MS = 10
+	AUTOMIX_ATTACK_MS = 5
+)
+
+func (canvas *CanvasPane) newAutomix(x, y int32) *Node {
+	n := canvas.newNode("automix", "automix", hexcolor(0x694ae9), x, y)
+	n.params = makeParams(automixParams)
+	canvas.mixerMenu(n)
+	return n
+}
+
+// automixer shares the gain between its inputs the way a Dugan
+// automixer does: each gets its part of the total level, so the loudest
+// speaker is up and the bleed of them on the other mics is down.
+// Whoever spoke last stays up through silence, and a speaker's gain is
+// held for a while before it goes down, so pauses don't pump.
+type automixer struct {
+	params []*Param
+	rate float64
+	// per input: the level followed, the gain, and how many frames the
+	// gain is still held
+	level []float64
+	gain []float64
+	held []int
+}
+
+func newAutomixer(params []*Param, format Format) *automixer {
+	return &automixer{params: params, rate: format.Rate}
+}
+
+func (a *automixer) coeff(ms float64) float64 {
+	return math.Exp(-1 / (ms / 1000 * a.rate))
+}
+
+// Mix sums the inputs into out with the gain shared between them
+func (a *automixer) Mix(out *Block, in []*Block) {
+	if len(a.level) != len(in) {
+		a.level = make([]float64, len(in))
+		a.gain = make([]float64, len(in))
+		a.held = make([]int, len(in))
+		for i := range a.gain {
+			a.gain[i] = 1
+		}
+	}
+	for _, b := range in {
+		out.Extend(b.Frames)
+	}
+	floor := dbToGain(a.params[0].Get())
+	threshold := dbToGain(a.params[1].Get())
+	hold := int(a.params[2].Get() / 1000 * a.rate)
+	release, attack := a.coeff(a.params[3].Get()), a.coeff(AUTOMIX_ATTACK_MS)
+	follow := a.coeff(AUTOMIX_LEVEL_MS)
+
+	ch := out.Channels
+	data := out.Samples()
+	for f := 0; f < out.Frames; f++ {
+		total := 0.0
+		for i, b := range in {
+			peak := 0.0
+			if f < b.Frames {
+				for _, s := range b.Data[f * ch:(f + 1) * ch] {
+					peak = math.Max(peak, math.Abs(float64(s)))
+				}
+			}
+			a.level[i] = follow * a.level[i] + (1 - follow) * peak * peak
+			total += a.level[i]
+		}
+		for i, b := range in {
+			g := a.gain[i]
+			// below the threshold nobody is talking, so nothing changes
+			if total > threshold * threshold {
+				// the levels are powers; sharing them out as gains
+				// keeps the power of the mix the same
+				target := math.Max(floor, math.Sqrt(a.level[i] / total))
+				if target >= g {
+					g = attack * g + (1 - attack) * target
+					a.held[i] = hold
+				} else if a.held[i] > 0 {
+					a.held[i]--
+				} else {
+					g = release * g + (1 - release) * target
+				}
+			}
+			a.gain[i] = g
+			if f < b.Frames {
+				for c := 0; c < ch; c++ {
+					data[f * ch + c] += b.Data[f * ch + c] * float32(g)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"math"
+
+	"github.com/krig/Go-SDL2/sdl"
+)
+
+const (
+	MIN_ZOOM = 0.25
+	MAX_ZOOM = 4.0
+	ZOOM_STEP = 1.1
+	MINIMAP_WIDTH = int32(160)
+	MINIMAP_HEIGHT = int32(100)
+)
+
+// Camera maps canvas (world) coordinates onto the screen. Node
+// positions are kept in world coordinates, and everything drawn
+// or hit tested on the canvas goes through the camera.
+type Camera struct {
+	// world position shown in the top left corner of the view
+	X, Y float64
+	Zoom float64
+	view sdl.Rect
+}
+
+func (cam *Camera) Init(view sdl.Rect) {
+	cam.view = view
+	cam.Zoom = 1
+}
+
+func (cam *Camera) SetView(view sdl.Rect) {
+	cam.view = view
+}
+
+func (cam *Camera) ToScreen(x, y float64) (int32, int32) {
+	return cam.view.X + int32(math.Floor((x - cam.X) * cam.Zoom + 0.5)),
+		cam.view.Y + int32(math.Floor((y - cam.Y) * cam.Zoom + 0.5))
+}
+
+func (cam *Camera) ToWorld(x, y int32) (float64, float64) {
+	return cam.X + float64(x - cam.view.X) / cam.Zoom,
+		cam.Y + float64(y - cam.view.Y) / cam.Zoom
+}
+
+// Rect returns where the given world rectangle ends up on screen
+func (cam *Camera) Rect(r sdl.Rect) sdl.Rect {
+	x0, y0 := cam.ToScreen(float64(r.X), float64(r.Y))
+	x1, y1 := cam.ToScreen(float64(r.X + r.W), float64(r.Y + r.H))
+	return sdl.Rect{x0, y0, x1 - x0, y1 - y0}
+}
+
+// Pan moves the view by the given number of screen pixels
+func (cam *Camera) Pan(dx, dy int32) {
+	cam.X -= float64(dx) / cam.Zoom
+	cam.Y -= float64(dy) / cam.Zoom
+}
+
+// ZoomAt scales the view by factor, keeping the world point
+// under the given screen position in place
+func (cam *Camera) ZoomAt(x, y int32, factor float64) {
+	wx, wy := cam.ToWorld(x, y)
+	cam.Zoom = math.Max(MIN_ZOOM, math.Min(MAX_ZOOM, cam.Zoom * factor))
+	cam.X = wx - float64(x - cam.view.X) / cam.Zoom
+	cam.Y = wy - float64(y - cam.view.Y) / cam.Zoom
+}
+
+// Fit zooms and pans so that the world rectangle fills the view
+func (cam *Camera) Fit(bounds sdl.Rect, margin int32) {
+	if bounds.W <= 0 || bounds.H <= 0 {
+		return
+	}
+	zx := float64(cam.view.W - margin*2) / float64(bounds.W)
+	zy := float64(cam.view.H - margin*2) / float64(bounds.H)
+	cam.Zoom = math.Max(MIN_ZOOM, math.Min(MAX_ZOOM, math.Min(math.Min(zx, zy), 1)))
+	cx := float64(bounds.X) + float64(bounds.W) / 2
+	cy := float64(bounds.Y) + float64(bounds.H) / 2
+	cam.X = cx - float64(cam.view.W) / 2 / cam.Zoom
+	cam.Y = cy - float64(cam.view.H) / 2 / cam.Zoom
+}
+
+// CenterOn pans so that the world point is in the middle of the view
+func (cam *Camera) CenterOn(x, y float64) {
+	cam.X = x - float64(cam.view.W) / 2 / cam.Zoom
+	cam.Y = y - float64(cam.view.H) / 2 / cam.Zoom
+}
+
+// Visible returns the part of the world that is in view
+func (cam *Camera) Visible() sdl.Rect {
+	return sdl.Rect{int32(cam.X), int32(cam.Y),
+		int32(float64(cam.view.W) / cam.Zoom), int32(float64(cam.view.H) / cam.Zoom)}
+}
+
+// unionRect returns the smallest rectangle covering both a and b.
+// An empty a is ignored.
+func unionRect(a, b sdl.Rect) sdl.Rect {
+	if a.W <= 0 || a.H <= 0 {
+		return b
+	}
+	x0 := int32(math.Min(float64(a.X), float64(b.X)))
+	y0 := int32(math.Min(float64(a.Y), float64(b.Y)))
+	x1 := int32(math.Max(float64(a.X + a.W), float64(b.X + b.W)))
+	y1 := int32(math.Max(float64(a.Y + a.H), float64(b.Y + b.H)))
+	return sdl.Rect{x0, y0, x1 - x0, y1 - y0}
+}
+
+// Bounds returns the world rectangle covering every node
+func (canvas *CanvasPane) Bounds() sdl.Rect {
+	var bounds sdl.Rect
+	for _, n := range canvas.nodes {
+		bounds = unionRect(bounds, n.GetPos())
+	}
+	return bounds
+}
+
+// FitToContent shows every node on the canvas
+func (canvas *CanvasPane) FitToContent() {
+	canvas.cam.Fit(canvas.Bounds(), GRID_MARGIN)
+}
+
+// Minimap is a small overview of the whole canvas, with the
+// part in view outlined. Clicking or dragging in it moves the view.
+type Minimap struct {
+	Widget
+	canvas *CanvasPane
+	dragging bool
+	// the world rectangle shown in the minimap and its scale
+	world sdl.Rect
+	scale float64
+}
+
+func (mm *Minimap) Init(canvas *CanvasPane) {
+	mm.canvas = canvas
+}
+
+// UpdateLayout docks the minimap in the bottom left corner of space
+func (mm *Minimap) UpdateLayout(space sdl.Rect) {
+	mm.Pos = sdl.Rect{space.X + 4, space.Y + space.H - MINIMAP_HEIGHT - 4, MINIMAP_WIDTH, MINIMAP_HEIGHT}
+}
+
+func (mm *Minimap) toMap(r sdl.Rect) sdl.Rect {
+	return sdl.Rect{mm.Pos.X + int32(float64(r.X - mm.world.X) * mm.scale),
+		mm.Pos.Y + int32(float64(r.Y - mm.world.Y) * mm.scale),
+		int32(math.Max(1, float64(r.W) * mm.scale)),
+		int32(math.Max(1, float64(r.H) * mm.scale))}
+}
+
+func (mm *Minimap) Draw(rend *sdl.Renderer) {
+	cam := &mm.canvas.cam
+	view := cam.Visible()
+	mm.world = unionRect(mm.canvas.Bounds(), view)
+	mm.scale = math.Min(float64(mm.Pos.W) / float64(mm.world.W), float64(mm.Pos.H) / float64(mm.world.H))
+
+	rend.SetDrawColor(mm.canvas.rsc.TitleBarColor)
+	rend.FillRect(&mm.Pos)
+	rend.SetDrawColor(darken(mm.canvas.rsc.TitleBarColor, 20))
+	rend.DrawRect(&mm.Pos)
+	for _, n := range mm.canvas.nodes {
+		r := mm.toMap(n.GetPos())
+		rend.SetDrawColor(n.fade.Get())
+		rend.FillRect(&r)
+	}
+	v := mm.toMap(view)
+	rend.SetDrawColor(hexcolor(0x303030))
+	rend.DrawRect(&v)
+}
+
+func (mm *Minimap) Destroy() {
+}
+
+func (mm *Minimap) centerOn(x, y int32) {
+	if mm.scale <= 0 {
+		return
+	}
+	wx := float64(mm.world.X) + float64(x - mm.Pos.X) / mm.scale
+	wy := float64(mm.world.Y) + float64(y - mm.Pos.Y) / mm.scale
+	mm.canvas.cam.CenterOn(wx, wy)
+}
+
+func (mm *Minimap) OnMouseMotionEvent(event *sdl.MouseMotionEvent) bool {
+	if mm.dragging {
+		mm.centerOn(event.X, event.Y)
+		return false
+	}
+	return true
+}
+
+func (mm *Minimap) OnMouseButtonEvent(event *sdl.MouseButtonEvent) bool {
+	if event.Button != sdl.BUTTON_LEFT {
+		return true
+	}
+	if event.State == sdl.PRESSED && mm.Pos.Contains(event.X, event.Y) {
+		mm.dragging = true
+		mm.centerOn(event.X, event.Y)
+		return false
+	}
+	if event.State == sdl.RELEASED && mm.dragging {
+		mm.dragging = false
+		return false
+	}
+	return true
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"github.com/krig/Go-SDL2/sdl"
+)
+
+const (
+	FADE_SPEED = 8.0
+	// how far nodes outside the selected chain fade into the background
+	FADE_AMOUNT = 0.75
+)
+
+// chainOf returns the selected node together with every node
+// upstream and downstream of it
+func (canvas *CanvasPane) chainOf(node *Node) map[*Node]bool {
+	chain := map[*Node]bool{node: true}
+	for n := node.next; n != nil && !chain[n]; n = n.next {
+		chain[n] = true
+	}
+
+	prev := make(map[*Node][]*Node)
+	for _, n := range canvas.nodes {
+		if n.next != nil {
+			prev[n.next] = append(prev[n.next], n)
+		}
+	}
+	queue := []*Node{node}
+	for len(queue) > 0 {
+		m := queue[0]
+		queue = queue[1:]
+		for _, p := range prev[m] {
+			if !chain[p] {
+				chain[p] = true
+				queue = append(queue, p)
+			}
+		}
+	}
+	return chain
+}
+
+// reachesOutput is true if following the links from node ends up in an output
+func reachesOutput(node *Node) bool {
+	seen := map[*Node]bool{}
+	for n := node; n != nil && !seen[n]; n = n.next {
+		if n.name == "output" {
+			return true
+		}
+		seen[n] = true
+	}
+	return false
+}
+
+// UpdateAnimations works out which nodes and links are part of the
+// selected chain and fades everything else towards the background.
+func (canvas *CanvasPane) UpdateAnimations(dt float64) {
+	bg := canvas.rsc.BackgroundColor
+	canvas.highlight = nil
+	if canvas.selected != nil {
+		canvas.highlight = canvas.chainOf(canvas.selected)
+	}
+	canvas.dangling = make(map[*Node]bool)
+
+	colors, offsets := canvas.chainColors()
+	canvas.offsets = offsets
+	for _, n := range canvas.nodes {
+		if !reachesOutput(n) {
+			canvas.dangling[n] = true
+		}
+		faded := canvas.highlight != nil && !canvas.highlight[n]
+
+		target := n.color
+		if faded {
+			target = blend(n.color, bg, FADE_AMOUNT)
+		}
+		n.fade.SetTarget(target)
+		n.fade.Update(dt)
+
+		if n.next == nil {
+			continue
+		}
+		clr, ok := colors[n]
+		if !ok {
+			clr = hexcolor(chainPalette[0])
+		}
+		if faded || (canvas.highlight != nil && !canvas.highlight[n.next]) {
+			clr = blend(clr, bg, FADE_AMOUNT)
+		}
+		n.linkfade.SetTarget(clr)
+		n.linkfade.Update(dt)
+	}
+}
+
+// DrawWarnings marks nodes that never reach an output with a red
+// triangle, and explains it under the selected node.
+func (canvas *CanvasPane) DrawWarnings(rend *sdl.Renderer) {
+	rend.SetDrawColor(hexcolor(0xff3015))
+	for n := range canvas.dangling {
+		pos := canvas.cam.Rect(n.GetPos())
+		x, y := pos.X + pos.W - 5, pos.Y - 4
+		rend.DrawLine(x, y, x - 5, y + 9)
+		rend.DrawLine(x - 5, y + 9, x + 5, y + 9)
+		rend.DrawLine(x + 5, y + 9, x, y)
+		rend.DrawLine(x, y + 3, x, y + 6)
+	}
+	if canvas.selected != nil && canvas.dangling[canvas.selected] {
+		pos := canvas.cam.Rect(canvas.selected.GetPos())
+		canvas.warning.Pos = sdl.Rect{pos.X, pos.Y + pos.H + 2, canvas.warning.texwidth, canvas.warning.texheight}
+		canvas.warning.Draw(rend)
+	}
+}
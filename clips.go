@@ -0,0 +1,410 @@
+package main
+
+import (
+	"log"
+	"math"
+	"path/filepath"
+	"sort"
+
+	"github.com/krig/go-sox"
+)
+
+// fade curves
+const (
+	CURVE_LINEAR = "linear"
+	CURVE_POWER = "equal power"
+	CURVE_S = "s-curve"
+)
+
+var fadeCurves = []string{CURVE_LINEAR, CURVE_POWER, CURVE_S}
+
+// the shortest a clip can be trimmed to, in seconds
+const MIN_CLIP = 0.05
+
+// Clip is a part of a file placed on a track. Editing a clip never
+// touches the file, it only changes which part of it plays and when.
+type Clip struct {
+	File string `json:"file"`
+	// where the clip starts on the timeline and how long it plays, in seconds
+	Start float64 `json:"start"`
+	Length float64 `json:"length"`
+	// where in the file the clip, or the looped region, begins
+	Offset float64 `json:"offset"`
+	// how far into the loop the clip starts
+	Skip float64 `json:"skip,omitempty"`
+	// the length of the file, how far a trim can be opened up again
+	FileLength float64 `json:"file_length"`
+	FadeIn float64 `json:"fade_in,omitempty"`
+	FadeOut float64 `json:"fade_out,omitempty"`
+	InCurve string `json:"in_curve,omitempty"`
+	OutCurve string `json:"out_curve,omitempty"`
+	// seconds from Offset repeated for as long as the clip is, 0
+	// plays straight through
+	Loop float64 `json:"loop,omitempty"`
+	// seconds of the file played per second of the timeline, set to
+	// make up for the clock of the recorder, 0 for 1
+	Speed float64 `json:"speed,omitempty"`
+}
+
+// probeFile opens an audio file just to find out its format and
+// length. Some files, like streamed MP3s, don't say how long they are,
+// so those are read through to count.
+func probeFile(filename string) (Format, float64) {
+	in := sox.OpenRead(filename)
+	if in == nil {
+		log.Println("Failed to open input file:", filename)
+		return Format{}, 0
+	}
+	defer in.Release()
+	s := in.Signal()
+	f := Format{s.Rate(), int(s.Channels())}
+	if f.Rate == 0 || f.Channels == 0 {
+		return f, 0
+	}
+	samples := int64(s.Length())
+	if samples == 0 {
+		buf := make([]int32, BLOCK_SIZE * f.Channels)
+		for {
+			n := in.Read(buf, uint(len(buf)))
+			if n <= 0 {
+				break
+			}
+			samples += n
+		}
+	}
+	return f, float64(samples) / float64(f.Channels) / f.Rate
+}
+
+// newClip returns a clip playing all of a file from the given time
+func newClip(filename string, start float64) (Clip, Format) {
+	format, length := probeFile(filename)
+	return Clip{File: filename, Start: start, Length: length, FileLength: length}, format
+}
+
+func (c *Clip) End() float64 {
+	return c.Start + c.Length
+}
+
+func (c *Clip) Name() string {
+	return filepath.Base(c.File)
+}
+
+// speed returns how many seconds of the file play per second. Offset,
+// Skip and Loop are in seconds of the file, Start and Length are on
+// the timeline.
+func (c *Clip) speed() float64 {
+	if c.Speed <= 0 {
+		return 1
+	}
+	return c.Speed
+}
+
+// fadeGain returns the gain at x from 0 to 1 along a fade in
+func fadeGain(curve string, x float64) float64 {
+	x = math.Max(0, math.Min(1, x))
+	switch curve {
+	case CURVE_POWER:
+		return math.Sin(x * math.Pi / 2)
+	case CURVE_S:
+		return (1 - math.Cos(x * math.Pi)) / 2
+	}
+	return x
+}
+
+// gain returns the fade gain t seconds into the clip
+func (c *Clip) gain(t float64) float64 {
+	g := 1.0
+	if c.FadeIn > 0 && t < c.FadeIn {
+		g *= fadeGain(c.InCurve, t / c.FadeIn)
+	}
+	if rest := c.Length - t; c.FadeOut > 0 && rest < c.FadeOut {
+		g *= fadeGain(c.OutCurve, rest / c.FadeOut)
+	}
+	return g
+}
+
+// maxLength returns how long the clip can be made
+func (c *Clip) maxLength() float64 {
+	if c.Loop > 0 {
+		return math.Inf(1)
+	}
+	return (c.FileLength - c.Offset) / c.speed()
+}
+
+// crossfades returns the clips sorted by start time, with the fades
+// stretched over the part where a clip overlaps the end of the one
+// reaching furthest before it. A clip lying wholly inside another
+// just plays over it.
+func crossfades(clips []Clip) []Clip {
+	out := append([]Clip(nil), clips...)
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Start < out[j].Start })
+	last := 0
+	for i := 1; i < len(out); i++ {
+		a, b := &out[last], &out[i]
+		if b.End() < a.End() {
+			continue
+		}
+		last = i
+		overlap := a.End() - b.Start
+		if overlap <= 0 {
+			continue
+		}
+		if overlap > a.FadeOut {
+			a.FadeOut, a.OutCurve = overlap, CURVE_POWER
+		}
+		if overlap > b.FadeIn {
+			b.FadeIn, b.InCurve = overlap, CURVE_POWER
+		}
+	}
+	return out
+}
+
+// Split cuts the clip in two at a time on the timeline, returning the
+// second half. It returns false when the time isn't inside the clip.
+func (c *Clip) Split(t float64) (Clip, bool) {
+	if t <= c.Start + MIN_CLIP || t >= c.End() - MIN_CLIP {
+		return Clip{}, false
+	}
+	right := *c
+	at := t - c.Start
+	if c.Loop > 0 {
+		right.Skip = math.Mod(c.Skip + at * c.speed(), c.Loop)
+	} else {
+		right.Offset += at * c.speed()
+	}
+	right.Start = t
+	right.Length = c.Length - at
+	right.FadeIn = 0
+	c.Length = at
+	c.FadeOut = 0
+	c.clampFades()
+	right.clampFades()
+	return right, true
+}
+
+// TrimStart moves the start of the clip to a time on the timeline,
+// keeping the end where it is
+func (c *Clip) TrimStart(t float64) {
+	end := c.End()
+	t = math.Min(t, end - MIN_CLIP)
+	if c.Loop > 0 {
+		c.Skip = math.Mod(math.Mod(c.Skip + (t - c.Start) * c.speed(), c.Loop) + c.Loop, c.Loop)
+	} else {
+		t = math.Max(t, c.Start - c.Offset / c.speed())
+		c.Offset = math.Max(0, c.Offset + (t - c.Start) * c.speed())
+	}
+	c.Start = t
+	c.Length = end - t
+	c.clampFades()
+}
+
+// TrimEnd moves the end of the clip to a time on the timeline
+func (c *Clip) TrimEnd(t float64) {
+	c.Length = math.Max(MIN_CLIP, math.Min(t - c.Start, c.maxLength()))
+	c.clampFades()
+}
+
+// SetLoop turns looping on, repeating what the clip plays now, or off
+func (c *Clip) SetLoop(on bool) {
+	if on && c.Loop == 0 {
+		c.Loop = c.Length * c.speed()
+		c.Skip = 0
+	} else if !on && c.Loop > 0 {
+		c.Offset += c.Skip
+		c.Skip = 0
+		c.Loop = 0
+		c.Length = math.Min(c.Length, c.maxLength())
+	}
+	c.clampFades()
+}
+
+// SetFades sets the fade lengths, keeping them inside the clip
+func (c *Clip) SetFades(in, out float64) {
+	c.FadeIn, c.FadeOut = math.Max(0, in), math.Max(0, out)
+	c.clampFades()
+}
+
+func (c *Clip) clampFades() {
+	c.FadeIn = math.Min(c.FadeIn, c.Length)
+	c.FadeOut = math.Min(c.FadeOut, c.Length - c.FadeIn)
+}
+
+// clipFrames is a clip in frames of the track it plays on. Start and
+// length count frames of the timeline, the rest frames of the file,
+// and speed is how many frames of the file play per frame of the
+// timeline.
+type clipFrames struct {
+	start, length, offset, skip, loop int64
+	speed float64
+}
+
+// frames returns the clip on a timeline at one rate, read from a file
+// at another
+func (c *Clip) frames(rate, fileRate float64) clipFrames {
+	f := func(t, rate float64) int64 {
+		return int64(math.Floor(t * rate + 0.5))
+	}
+	return clipFrames{f(c.Start, rate), f(c.Length, rate), f(c.Offset, fileRate), f(c.Skip, fileRate), f(c.Loop, fileRate), c.speed() * fileRate / rate}
+}
+
+// run returns the frame of the file played i frames of the file into
+// the clip, and how many frames play from there before the loop wraps
+// around, or as many as wanted when it doesn't loop
+func (cf clipFrames) run(i, want int64) (int64, int64) {
+	if cf.loop > 0 {
+		phase := (cf.skip + i) % cf.loop
+		return cf.offset + phase, cf.loop - phase
+	}
+	return cf.offset + i, want
+}
+
+// clipReader reads the file of one clip for a voice
+type clipReader struct {
+	clip Clip
+	frames clipFrames
+	in *sox.Format
+	format Format
+	// the frame of the file the reader is at, -1 when unknown
+	pos int64
+	raw []int32
+	mapped Block
+	// frames of the clip from win, as the track hears them, kept for
+	// the interpolation of the next block
+	window []float32
+	win int64
+	// resamples a file at another rate than the track, nil otherwise
+	filter *sincFilter
+}
+
+func openClip(c Clip, track Format) *clipReader {
+	in := sox.OpenRead(c.File)
+	if in == nil {
+		log.Println("Failed to open input file:", c.File)
+		return nil
+	}
+	format := Format{in.Signal().Rate(), int(in.Signal().Channels())}
+	r := &clipReader{
+		clip: c,
+		frames: c.frames(track.Rate, format.Rate),
+		in: in,
+		format: format,
+		pos: -1,
+		raw: make([]int32, BLOCK_SIZE * format.Channels),
+		mapped: Block{Format: format, Data: make([]float32, BLOCK_SIZE * format.Channels)},
+	}
+	if format.Rate != track.Rate {
+		r.filter = newSincFilter(r.frames.speed)
+	}
+	return r
+}
+
+func (r *clipReader) Release() {
+	r.in.Release()
+}
+
+// mix adds the clip to a block of the track starting at a frame of the
+// timeline, with its fades. The file is interpolated at the speed of
+// the clip, which reads it straight through at a speed of 1, and
+// resampled when it is at another rate than the track.
+func (r *clipReader) mix(b *Block, from int64) {
+	cf := r.frames
+	first := from - cf.start
+	if first < 0 {
+		first = 0
+	}
+	last := from + int64(b.Frames) - cf.start
+	if last > cf.length {
+		last = cf.length
+	}
+	if first >= last {
+		return
+	}
+	ch := int64(b.Channels)
+	// the frames before the first and after the last the interpolation reads
+	before, after := int64(1), int64(2)
+	if r.filter != nil {
+		before, after = int64(r.filter.width - 1), int64(r.filter.width)
+	}
+	j0 := int64(float64(first) * cf.speed) - before
+	j1 := int64(float64(last - 1) * cf.speed) + after + 1
+	data := r.span(j0, j1 - j0, b.Channels)
+	for i := first; i < last; i++ {
+		p := float64(i) * cf.speed
+		j := int64(p)
+		g := float32(r.clip.gain(float64(i) / b.Rate))
+		in := data[(j - j0 - before) * ch:]
+		out := b.Data[(cf.start + i - from) * ch:]
+		if r.filter == nil {
+			t := float32(p - float64(j))
+			for c := int64(0); c < ch; c++ {
+				out[c] += hermite(in[c], in[c + ch], in[c + 2 * ch], in[c + 3 * ch], t) * g
+			}
+			continue
+		}
+		taps := r.filter.weights(p - float64(j))
+		for c := int64(0); c < ch; c++ {
+			var sum float32
+			for n, w := range taps {
+				sum += w * in[int64(n) * ch + c]
+			}
+			out[c] += sum * g
+		}
+	}
+}
+
+// span returns n frames of the file from frame j of the clip, in the
+// channels of the track, silent before the clip and after the file ends
+func (r *clipReader) span(j, n int64, channels int) []float32 {
+	ch := int64(channels)
+	if j < r.win || j > r.win + int64(len(r.window)) / ch {
+		r.window = r.window[:0]
+	} else {
+		r.window = append(r.window[:0], r.window[(j - r.win) * ch:]...)
+	}
+	r.win = j
+	for have := int64(len(r.window)) / ch; have < n; have = int64(len(r.window)) / ch {
+		at := j + have
+		want := n - have
+		if at < 0 {
+			if want > -at {
+				want = -at
+			}
+			r.window = append(r.window, make([]float32, want * ch)...)
+			continue
+		}
+		file, run := r.frames.run(at, want)
+		if want > run {
+			want = run
+		}
+		if want > BLOCK_SIZE {
+			want = BLOCK_SIZE
+		}
+		if r.read(file, want) == 0 {
+			r.window = append(r.window, make([]float32, (n - have) * ch)...)
+			break
+		}
+		r.window = append(r.window, mapChannels(&r.mapped, channels)...)
+	}
+	return r.window[:n * ch]
+}
+
+// read reads n frames from a frame of the file into mapped, seeking
+// when needed, and returns how many there were
+func (r *clipReader) read(file, n int64) int64 {
+	ch := int64(r.format.Channels)
+	if r.pos != file {
+		r.in.Seek(uint64(file * ch), 0)
+		r.pos = file
+	}
+	got := r.in.Read(r.raw[:n * ch], uint(n * ch)) / ch
+	if got <= 0 {
+		r.pos = -1
+		return 0
+	}
+	r.pos += got
+	r.mapped.Frames = int(got)
+	for i, s := range r.raw[:got * ch] {
+		r.mapped.Data[i] = float32(s) / (1 << 31)
+	}
+	return got
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+var declickParams = []ParamSpec{
+	{"sensitivity", UNIT_NONE, 0, 1, 0.5, false},
+}
+
+var declipParams = []ParamSpec{
+	{"sensitivity", UNIT_NONE, 0, 1, 0.5, false},
+}
+
+const (
+	// the longest click and the longest clipped peak fixed, in ms
+	CLICK_MAX_MS = 2
+	CLIP_MAX_MS = 5
+	// how long the level a click has to stand out from is averaged over
+	CLICK_LEVEL_MS = 20
+	// the samples looked back at around an event
+	REPAIR_CONTEXT = 4
+	// how far before where an event is found a repair may change the
+	// signal, so scanning starts that far into what is held back
+	REPAIR_REACH = 2
+	// the fewest samples a clipped run holds, how fast the ceiling it
+	// is measured against falls after the loudest peak, in dB per
+	// second, and how quiet a run can be and still count
+	CLIP_MIN_RUN = 3
+	CLIP_FALL_DB = 1
+	CLIP_FLOOR_DB = -30
+	// how far over the clipped run a rebuilt peak may go, in dB
+	CLIP_HEADROOM_DB = 6
+)
+
+// repairer holds the signal back long enough to see an event and what
+// comes after it before fixing it. Each channel keeps a little of what
+// has already gone out, what is held back, and the new block.
+type repairer struct {
+	delay int
+	buf [][]float64
+	// where to carry on scanning each channel, past the last fix
+	resume []int
+	// frames taken in before this block
+	in int64
+	// the frames of the events found, when asked for
+	record bool
+	events []int64
+}
+
+// scanner looks for events starting in x[from:to] of a channel, fixes
+// them in place and returns where they start and where to carry on
+type scanner func(x []float64, c, from, to int) ([]int, int)
+
+func newRepairer(format Format, delay int) *repairer {
+	r := &repairer{delay: delay}
+	for c := 0; c < format.Channels; c++ {
+		r.buf = append(r.buf, make([]float64, REPAIR_CONTEXT + delay))
+		r.resume = append(r.resume, REPAIR_CONTEXT + REPAIR_REACH)
+	}
+	return r
+}
+
+func (r *repairer) process(block *Block, scan scanner) {
+	ch := block.Channels
+	n := block.Frames
+	data := block.Samples()
+	for c := 0; c < ch; c++ {
+		x := r.buf[c]
+		for i := 0; i < n; i++ {
+			x = append(x, float64(data[i * ch + c]))
+		}
+		from := r.resume[c]
+		if from < REPAIR_CONTEXT + REPAIR_REACH {
+			from = REPAIR_CONTEXT + REPAIR_REACH
+		}
+		events, next := scan(x, c, from, REPAIR_CONTEXT + REPAIR_REACH + n)
+		if r.record && c == 0 {
+			for _, e := range events {
+				r.events = append(r.events, r.in - int64(r.delay) + int64(e - REPAIR_CONTEXT))
+			}
+		}
+		for i := 0; i < n; i++ {
+			data[i * ch + c] = float32(x[REPAIR_CONTEXT + i])
+		}
+		r.buf[c] = append(x[:0], x[n:]...)
+		r.resume[c] = next - n
+	}
+	r.in += int64(n)
+}
+
+// declicker finds samples that jump away from the signal around them
+// much more than it usually moves, and draws a curve over them. The
+// more sensitive, the smaller the jump that counts as a click.
+type declicker struct {
+	params []*Param
+	rate float64
+	repair *repairer
+	// per channel, the average size of the second difference
+	level []float64
+}
+
+func newDeclicker(node *Node, params []*Param, format Format) Processor {
+	return &declicker{
+		params: params,
+		rate: format.Rate,
+		repair: newRepairer(format, int(CLICK_MAX_MS * 2 / 1000 * format.Rate) + REPAIR_CONTEXT),
+		level: make([]float64, format.Channels),
+	}
+}
+
+func (d *declicker) Process(block *Block) {
+	d.repair.process(block, d.scan)
+}
+
+func (d *declicker) Latency() int {
+	return d.repair.delay
+}
+
+func (d *declicker) scan(x []float64, c, from, to int) ([]int, int) {
+	ratio := 20 - 16 * d.params[0].Get()
+	avg := math.Exp(-1 / (CLICK_LEVEL_MS / 1000 * d.rate))
+	longest := int(CLICK_MAX_MS / 1000 * d.rate)
+	diff := func(i int) float64 {
+		return math.Abs(x[i] - 2 * x[i - 1] + x[i - 2])
+	}
+	var events []int
+	i := from
+	for ; i < to; i++ {
+		e := diff(i)
+		if e > d.level[c] * ratio && d.level[c] > 0 {
+			// the click lasts while the signal keeps jumping
+			j := i + 1
+			for j < i + longest && diff(j) > d.level[c] * ratio / 2 {
+				j++
+			}
+			if j < i + longest {
+				a, b := i - 2, j + 1
+				for k := a + 1; k < b; k++ {
+					t := float32(k - a) / float32(b - a)
+					x[k] = float64(hermite(float32(x[a - 1]), float32(x[a]), float32(x[b]), float32(x[b + 1]), t))
+				}
+				events = append(events, i)
+				i = b
+				continue
+			}
+		}
+		d.level[c] = avg * d.level[c] + (1 - avg) * e
+	}
+	return events, i
+}
+
+// declipper finds flat runs of samples near the loudest the signal has
+// lately been, entered and left by a steep slope, and rebuilds the peak
+// that was cut off, following the slope on either side. The more
+// sensitive, the further below the loudest and the less flat a run
+// can be and still count as clipped.
+type declipper struct {
+	params []*Param
+	rate float64
+	repair *repairer
+	// per channel, the loudest sample seen, falling slowly after it
+	ceiling []float64
+	fall float64
+}
+
+func newDeclipper(node *Node, params []*Param, format Format) Processor {
+	return &declipper{
+		params: params,
+		rate: format.Rate,
+		repair: newRepairer(format, int(CLIP_MAX_MS / 1000 * format.Rate) + REPAIR_CONTEXT),
+		ceiling: make([]float64, format.Channels),
+		fall: dbToGain(-CLIP_FALL_DB / format.Rate),
+	}
+}
+
+func (d *declipper) Process(block *Block) {
+	d.repair.process(block, d.scan)
+}
+
+func (d *declipper) Latency() int {
+	return d.repair.delay
+}
+
+func (d *declipper) scan(x []float64, c, from, to int) ([]int, int) {
+	longest := int(CLIP_MAX_MS / 1000 * d.rate)
+	sensitivity := d.params[0].Get()
+	floor := dbToGain(CLIP_FLOOR_DB)
+	var events []int
+	i := from
+	for ; i < to; i++ {
+		d.ceiling[c] = math.Max(d.ceiling[c] * d.fall, math.Abs(x[i]))
+		ceiling := d.ceiling[c]
+		if math.Abs(x[i]) < math.Max(ceiling * dbToGain(-2 * sensitivity), floor) {
+			continue
+		}
+		// how far apart the samples of a flat run may be
+		flat := ceiling * (0.002 + 0.02 * sensitivity)
+		lo, hi := x[i], x[i]
+		j := i + 1
+		for j < i + longest && x[j] * x[i] > 0 && math.Max(hi, x[j]) - math.Min(lo, x[j]) <= flat {
+			lo, hi = math.Min(lo, x[j]), math.Max(hi, x[j])
+			j++
+		}
+		// the top of a smooth peak is flat too, but it isn't come at
+		// and left in steps much bigger than it moves along the top
+		steep := math.Max(4 * (hi - lo), ceiling * 0.001)
+		if j - i < CLIP_MIN_RUN || j >= i + longest || math.Abs(x[i] - x[i - 1]) < steep || math.Abs(x[j] - x[j - 1]) < steep {
+			continue
+		}
+		// a cubic from the last sample before to the first after,
+		// leaving them at the slopes they had
+		a, b := i - 1, j
+		span := float64(b - a)
+		m0, m1 := (x[a] - x[a - 1]) * span, (x[b + 1] - x[b]) * span
+		limit := math.Abs(x[i]) * dbToGain(CLIP_HEADROOM_DB)
+		for k := a + 1; k < b; k++ {
+			t := float64(k - a) / span
+			t2, t3 := t * t, t * t * t
+			y := (2 * t3 - 3 * t2 + 1) * x[a] + (t3 - 2 * t2 + t) * m0 + (-2 * t3 + 3 * t2) * x[b] + (t3 - t2) * m1
+			y = math.Max(-limit, math.Min(limit, y))
+			// the peak only ever goes further out than it was cut at
+			if x[k] > 0 {
+				x[k] = math.Max(x[k], y)
+			} else {
+				x[k] = math.Min(x[k], y)
+			}
+		}
+		events = append(events, i)
+		i = b
+	}
+	return events, i
+}
+
+// repairs is true for the effects that find and fix events
+func repairs(node *Node) bool {
+	return node.effect == "declick" || node.effect == "declip"
+}
+
+// marker is an event a repair effect found on a track, at a time on
+// the timeline
+type marker struct {
+	time float64
+	clip bool
+}
+
+// eventScan is the markers found on a track, and what they were
+// found for
+type eventScan struct {
+	track *Node
+	key string
+	markers []marker
+	scanning bool
+}
+
+// markersKey describes what the markers of a track depend on, and
+// returns the effects after it that show them. It is empty when there
+// are none.
+func markersKey(track *Node) (string, []*Node) {
+	var nodes []*Node
+	var key strings.Builder
+	for _, n := range pathFrom(track) {
+		if repairs(n) && n.markers.Get() {
+			nodes = append(nodes, n)
+			fmt.Fprintf(&key, "%d:%s:%g;", n.id, n.effect, n.params[0].Get())
+		}
+	}
+	if len(nodes) == 0 {
+		return "", nil
+	}
+	return key.String() + track.clipsKey(), nodes
+}
+
+// streamTrack mixes a track from the start to the end of its last
+// clip, a block at a time
+func streamTrack(t *track, fn func(b *Block)) {
+	var readers []*clipReader
+	var end int64
+	for _, c := range crossfades(t.clips) {
+		if r := openClip(c, t.format); r != nil {
+			readers = append(readers, r)
+			defer r.Release()
+			if e := r.frames.start + r.frames.length; e > end {
+				end = e
+			}
+		}
+	}
+	b := NewBlock(t.format)
+	for from := int64(0); from < end; from += BLOCK_SIZE {
+		frames := end - from
+		if frames > BLOCK_SIZE {
+			frames = BLOCK_SIZE
+		}
+		b.Clear(int(frames))
+		for _, r := range readers {
+			r.mix(b, from)
+		}
+		fn(b)
+	}
+}
+
+// repairerOf returns the repairer of a declick or declip processor
+func repairerOf(p Processor) *repairer {
+	switch p := p.(type) {
+	case *declicker:
+		return p.repair
+	case *declipper:
+		return p.repair
+	}
+	return nil
+}
+
+// scanEvents runs a track through repair processors, each on its own
+// copy, and returns where they found something to fix
+func scanEvents(t *track, procs []Processor) []marker {
+	var markers []marker
+	scratch := NewBlock(t.format)
+	for _, p := range procs {
+		repairerOf(p).record = true
+	}
+	streamTrack(t, func(b *Block) {
+		for _, p := range procs {
+			scratch.CopyFrom(b)
+			p.Process(scratch)
+		}
+	})
+	for _, p := range procs {
+		_, clip := p.(*declipper)
+		for _, e := range repairerOf(p).events {
+			markers = append(markers, marker{float64(e) / t.format.Rate, clip})
+		}
+	}
+	return markers
+}
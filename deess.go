@@ -0,0 +1,151 @@
+package main
+
+import (
+	"math"
+)
+
+var deessParams = []ParamSpec{
+	{"frequency", UNIT_HZ, 2000, 12000, 5500, true},
+	{"threshold", UNIT_DB, -60, 0, -30, false},
+	{"range", UNIT_DB, 0, 24, 12, false},
+}
+
+var deplosiveParams = []ParamSpec{
+	{"frequency", UNIT_HZ, 60, 400, 150, true},
+	{"threshold", UNIT_DB, -50, 0, -30, false},
+	{"sensitivity", UNIT_DB, 0, 24, 6, false},
+	{"reduction", UNIT_DB, 0, 40, 24, false},
+}
+
+// how fast the level of the bands is followed, in milliseconds
+const (
+	BAND_ATTACK_MS = 1
+	DEESS_RELEASE_MS = 60
+	PLOSIVE_RELEASE_MS = 30
+	PLOSIVE_RECOVER_MS = 80
+)
+
+// splitter splits a block into the part above a crossover and the
+// part below, which add back up to the block
+type splitter struct {
+	filter *biquad
+	low, high *Block
+}
+
+func newSplitter(format Format, params []*Param) *splitter {
+	return &splitter{
+		filter: newBiquad(format, params, func(rate float64, p []*Param) biquadCoeffs {
+			return highpassCoeffs(rate, p[0].Get(), math.Sqrt2 / 2)
+		}),
+		low: NewBlock(format),
+		high: NewBlock(format),
+	}
+}
+
+func (s *splitter) split(block *Block) {
+	s.high.CopyFrom(block)
+	s.filter.Process(s.high)
+	s.low.CopyFrom(block)
+	low, high := s.low.Samples(), s.high.Samples()
+	for i := range low {
+		low[i] -= high[i]
+	}
+}
+
+// follow moves a level towards a peak, with separate coefficients
+// going up and coming down
+func follow(level, peak, attack, release float64) float64 {
+	if peak > level {
+		return attack * level + (1 - attack) * peak
+	}
+	return release * level + (1 - release) * peak
+}
+
+// peakAt returns the loudest channel of a frame
+func peakAt(data []float32) float64 {
+	peak := 0.0
+	for _, s := range data {
+		peak = math.Max(peak, math.Abs(float64(s)))
+	}
+	return peak
+}
+
+func coeff(ms, rate float64) float64 {
+	return math.Exp(-1 / (ms / 1000 * rate))
+}
+
+// deesser turns the band above the frequency down by as much as it
+// goes over the threshold, up to the range, leaving the rest of the
+// voice alone. Listening plays the band it is listening to instead.
+type deesser struct {
+	params []*Param
+	listen *Toggle
+	rate float64
+	bands *splitter
+	level float64
+}
+
+func newDeesser(node *Node, params []*Param, format Format) Processor {
+	return &deesser{params: params, listen: &node.listen, rate: format.Rate, bands: newSplitter(format, params)}
+}
+
+func (d *deesser) Process(block *Block) {
+	d.bands.split(block)
+	threshold, limit := d.params[1].Get(), d.params[2].Get()
+	attack, release := coeff(BAND_ATTACK_MS, d.rate), coeff(DEESS_RELEASE_MS, d.rate)
+	listen := d.listen.Get()
+	ch := block.Channels
+	data, low, high := block.Samples(), d.bands.low.Samples(), d.bands.high.Samples()
+	for i := 0; i < len(data); i += ch {
+		d.level = follow(d.level, peakAt(high[i:i + ch]), attack, release)
+		reduction := math.Min(limit, math.Max(0, gainToDb(d.level) - threshold))
+		g := float32(dbToGain(-reduction))
+		for c := i; c < i + ch; c++ {
+			if listen {
+				data[c] = high[c]
+			} else {
+				data[c] = low[c] + high[c] * g
+			}
+		}
+	}
+}
+
+// deplosive takes out the low end of a pop. A pop is a burst below
+// the frequency over the threshold and louder than the rest of the
+// voice by the sensitivity; the low band is turned down by the
+// reduction until it has passed.
+type deplosive struct {
+	params []*Param
+	rate float64
+	bands *splitter
+	low, high float64
+	gain float64
+}
+
+func newDeplosive(node *Node, params []*Param, format Format) Processor {
+	return &deplosive{params: params, rate: format.Rate, bands: newSplitter(format, params), gain: 1}
+}
+
+func (d *deplosive) Process(block *Block) {
+	d.bands.split(block)
+	threshold := dbToGain(d.params[1].Get())
+	sensitivity := dbToGain(d.params[2].Get())
+	floor := dbToGain(-d.params[3].Get())
+	attack, release := coeff(BAND_ATTACK_MS, d.rate), coeff(PLOSIVE_RELEASE_MS, d.rate)
+	recover := coeff(PLOSIVE_RECOVER_MS, d.rate)
+	ch := block.Channels
+	data, low, high := block.Samples(), d.bands.low.Samples(), d.bands.high.Samples()
+	for i := 0; i < len(data); i += ch {
+		d.low = follow(d.low, peakAt(low[i:i + ch]), attack, release)
+		d.high = follow(d.high, peakAt(high[i:i + ch]), attack, release)
+		target := 1.0
+		if d.low > threshold && d.low > d.high * sensitivity {
+			target = floor
+		}
+		d.gain = follow(d.gain, target, recover, attack)
+		g := float32(d.gain)
+		for c := i; c < i + ch; c++ {
+			data[c] = low[c] * g + high[c]
+		}
+	}
+}
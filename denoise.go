@@ -0,0 +1,231 @@
+package main
+
+import (
+	"log"
+	"math"
+	"math/cmplx"
+)
+
+const (
+	// the length of the frames the spectrum is taken of, and how far
+	// apart they start
+	DENOISE_FRAME = 2048
+	DENOISE_HOP = DENOISE_FRAME / 4
+	// the most of a selection a noise profile is learned from, in seconds
+	PROFILE_SECONDS = 10
+)
+
+var denoiseParams = []ParamSpec{
+	{"reduction", UNIT_DB, 0, 40, 12, false},
+	{"threshold", UNIT_DB, -6, 12, 3, false},
+	{"smoothing", UNIT_NONE, 0, 1, 0.5, false},
+}
+
+// NoiseProfile is the spectrum of a stretch of noise, learned from a
+// track and saved with the denoise node
+type NoiseProfile struct {
+	Rate float64 `json:"rate"`
+	// the average magnitude in each bin of a DENOISE_FRAME long frame
+	Bins []float64 `json:"bins"`
+}
+
+// denoiseWindow is a square root Hann window, used going in and coming
+// out so the frames add back up to the signal
+func denoiseWindow() []float64 {
+	w := make([]float64, DENOISE_FRAME)
+	for i := range w {
+		w[i] = math.Sqrt(0.5 - 0.5 * math.Cos(2 * math.Pi * float64(i) / DENOISE_FRAME))
+	}
+	return w
+}
+
+// learnProfile averages the spectrum of a track over a region
+func learnProfile(t *track, region Region) *NoiseProfile {
+	x := readMono(t, region.Start, math.Min(region.Length(), PROFILE_SECONDS))
+	w := denoiseWindow()
+	p := &NoiseProfile{Rate: t.format.Rate, Bins: make([]float64, DENOISE_FRAME / 2 + 1)}
+	spec := make([]complex128, DENOISE_FRAME)
+	frames := 0
+	for at := 0; at + DENOISE_FRAME <= len(x); at += DENOISE_HOP {
+		for i := range spec {
+			spec[i] = complex(float64(x[at + i]) * w[i], 0)
+		}
+		fft(spec, false)
+		for k := range p.Bins {
+			p.Bins[k] += cmplx.Abs(spec[k])
+		}
+		frames++
+	}
+	if frames == 0 {
+		return nil
+	}
+	for k := range p.Bins {
+		p.Bins[k] /= float64(frames)
+	}
+	return p
+}
+
+// at returns the noise at a bin of a frame at another sample rate
+func (p *NoiseProfile) at(k int, rate float64) float64 {
+	f := float64(k) * rate / p.Rate
+	i := int(f)
+	if i >= len(p.Bins) - 1 {
+		return p.Bins[len(p.Bins) - 1]
+	}
+	t := f - float64(i)
+	return p.Bins[i] * (1 - t) + p.Bins[i + 1] * t
+}
+
+// LearnNoise teaches every denoise node after a track the noise in a
+// region of it
+func (canvas *CanvasPane) LearnNoise(track *Node, region Region) {
+	var nodes []*Node
+	for _, n := range pathFrom(track) {
+		if n.effect == "denoise" {
+			nodes = append(nodes, n)
+		}
+	}
+	if len(nodes) == 0 {
+		log.Println("Add a denoise effect after", track.label.Text, "first.")
+		return
+	}
+	if region.Length() * track.format.Rate < DENOISE_FRAME {
+		log.Println("Select some noise on", track.label.Text, "first.")
+		return
+	}
+	p := learnProfile(track.snapshot(), region)
+	if p == nil {
+		return
+	}
+	for _, n := range nodes {
+		n.profile = p
+	}
+	log.Printf("Learned the noise of %s from %.1fs\n", track.label.Text, math.Min(region.Length(), PROFILE_SECONDS))
+}
+
+// denoiser takes the learned noise out of every frame of the spectrum.
+// Bins not far enough above the noise are turned down, and the gains
+// are smoothed across the spectrum and over time, so what's left of
+// the noise doesn't twitter. The output is a frame late.
+type denoiser struct {
+	params []*Param
+	noise []float64
+	window []float64
+	// per channel: the last frame in, what is added up coming out, and
+	// the gain of each bin
+	in, out, gains [][]float64
+	pos int
+	spec []complex128
+	g []float64
+}
+
+func newDenoiser(node *Node, params []*Param, format Format) Processor {
+	if node.profile == nil {
+		log.Println("No noise profile learned for", node.label.Text)
+	}
+	d := &denoiser{params: params, window: denoiseWindow(), spec: make([]complex128, DENOISE_FRAME)}
+	if node.profile != nil {
+		d.noise = make([]float64, DENOISE_FRAME / 2 + 1)
+		for k := range d.noise {
+			d.noise[k] = node.profile.at(k, format.Rate)
+		}
+	}
+	d.g = make([]float64, DENOISE_FRAME / 2 + 1)
+	for c := 0; c < format.Channels; c++ {
+		d.in = append(d.in, make([]float64, DENOISE_FRAME))
+		d.out = append(d.out, make([]float64, DENOISE_FRAME))
+		gains := make([]float64, DENOISE_FRAME / 2 + 1)
+		for k := range gains {
+			gains[k] = 1
+		}
+		d.gains = append(d.gains, gains)
+	}
+	return d
+}
+
+func (d *denoiser) Process(block *Block) {
+	if d.noise == nil {
+		return
+	}
+	ch := block.Channels
+	data := block.Samples()
+	for i := 0; i < len(data); i += ch {
+		for c := 0; c < ch; c++ {
+			d.in[c][DENOISE_FRAME - DENOISE_HOP + d.pos] = float64(data[i + c])
+			data[i + c] = float32(d.out[c][d.pos])
+		}
+		d.pos++
+		if d.pos == DENOISE_HOP {
+			for c := 0; c < ch; c++ {
+				d.frame(c)
+			}
+			d.pos = 0
+		}
+	}
+}
+
+// Latency returns the frame the output is behind by, or nothing when
+// there is no profile and the signal passes straight through
+func (d *denoiser) Latency() int {
+	if d.noise == nil {
+		return 0
+	}
+	return DENOISE_FRAME
+}
+
+// frame denoises the last frame of a channel and adds it to the output
+func (d *denoiser) frame(c int) {
+	floor := dbToGain(-d.params[0].Get())
+	over := dbToGain(d.params[1].Get())
+	smoothing := d.params[2].Get()
+	in, out, gains := d.in[c], d.out[c], d.gains[c]
+
+	for i := range d.spec {
+		d.spec[i] = complex(in[i] * d.window[i], 0)
+	}
+	fft(d.spec, false)
+	for k := range d.g {
+		d.g[k] = 1
+		if mag := cmplx.Abs(d.spec[k]); mag > 0 {
+			d.g[k] = 1 - d.noise[k] * over / mag
+		}
+		d.g[k] = math.Max(floor, d.g[k])
+	}
+	// smooth across neighbouring bins, then over time, opening up
+	// faster than closing down
+	width := int(smoothing * 4)
+	fall, rise := 0.9 * smoothing, 0.3 * smoothing
+	for k := range gains {
+		sum, n := 0.0, 0
+		for j := k - width; j <= k + width; j++ {
+			if j >= 0 && j < len(d.g) {
+				sum += d.g[j]
+				n++
+			}
+		}
+		g := sum / float64(n)
+		coeff := fall
+		if g > gains[k] {
+			coeff = rise
+		}
+		gains[k] = coeff * gains[k] + (1 - coeff) * g
+	}
+	for k := range d.spec {
+		bin := k
+		if k > DENOISE_FRAME / 2 {
+			bin = DENOISE_FRAME - k
+		}
+		d.spec[k] *= complex(gains[bin], 0)
+	}
+	fft(d.spec, true)
+
+	copy(out, out[DENOISE_HOP:])
+	for i := DENOISE_FRAME - DENOISE_HOP; i < DENOISE_FRAME; i++ {
+		out[i] = 0
+	}
+	// the windows overlap four times and add up to 2
+	for i := range out {
+		out[i] += real(d.spec[i]) * d.window[i] / 2
+	}
+	copy(in, in[DENOISE_HOP:])
+}
@@ -0,0 +1,520 @@
+package main
+
+import (
+	"bytes"
+
+	"github.com/krig/Go-SDL2/sdl"
+)
+
+const (
+	DIALOG_TITLE_HEIGHT = int32(24)
+	DIALOG_BUTTON_HEIGHT = int32(24)
+	DIALOG_INSET = int32(8)
+)
+
+// KeyLover is implemented by widgets that want keyboard events
+type KeyLover interface {
+	OnKeyboardEvent(event *sdl.KeyboardEvent) bool
+}
+
+// TextLover is implemented by widgets that take typed text
+type TextLover interface {
+	OnTextInputEvent(event *sdl.TextInputEvent) bool
+}
+
+// Modal is a window that takes all input while it is open
+type Modal interface {
+	Visual
+	Layout
+	MouseLover
+	WheelLover
+	KeyLover
+	IsOpen() bool
+}
+
+// TextButton is a button with a text label instead of a texture
+type TextButton struct {
+	Widget
+	label Label
+	Color sdl.Color
+	hover bool
+	pressed bool
+	clickhandler func()
+}
+
+// Dialog is a modal window with a title and a row of buttons along
+// the bottom. The space in between is left to whatever embeds it.
+type Dialog struct {
+	Widget
+	rsc *Resources
+	title Label
+	buttons []*TextButton
+	screen sdl.Rect
+	// the size asked for, kept when a small window shrinks the dialog
+	w, h int32
+	Visible bool
+	// triggered by return and escape
+	accept, cancel func()
+}
+
+func (button *TextButton) Init(rsc *Resources, space sdl.Rect, text string) {
+	button.Pos = space
+	button.Color = darken(rsc.TitleBarColor, 20)
+	button.label.Init(rsc.renderer, space, text, rsc.TitleFont, rsc.TitleColor)
+	if button.Pos.W < button.label.texwidth + 16 {
+		button.Pos.W = button.label.texwidth + 16
+	}
+}
+
+func (button *TextButton) OnClick(handler func()) {
+	button.clickhandler = handler
+}
+
+func (button *TextButton) Destroy() {
+	button.label.Destroy()
+}
+
+func (button *TextButton) Draw(rend *sdl.Renderer) {
+	clr := button.Color
+	if button.pressed {
+		clr = darken(clr, 20)
+	} else if button.hover {
+		clr = lighten(clr, 20)
+	}
+	rend.SetDrawColor(clr)
+	rend.FillRect(&button.Pos)
+	rend.SetDrawColor(darken(clr, 30))
+	rend.DrawRect(&button.Pos)
+	button.label.Pos = button.Pos
+	button.label.Draw(rend)
+}
+
+func (button *TextButton) OnMouseMotionEvent(event *sdl.MouseMotionEvent) bool {
+	button.hover = button.Pos.Contains(event.X, event.Y)
+	return true
+}
+
+func (button *TextButton) OnMouseButtonEvent(event *sdl.MouseButtonEvent) bool {
+	if event.Button != sdl.BUTTON_LEFT {
+		return true
+	}
+	contains := button.Pos.Contains(event.X, event.Y)
+	if event.State == sdl.PRESSED && contains {
+		button.pressed = true
+		return false
+	}
+	if event.State == sdl.RELEASED && button.pressed {
+		button.pressed = false
+		if contains && button.clickhandler != nil {
+			button.clickhandler()
+		}
+		return false
+	}
+	return true
+}
+
+func (dialog *Dialog) Init(rsc *Resources, title string, w, h int32) {
+	dialog.rsc = rsc
+	dialog.Pos = sdl.Rect{0, 0, w, h}
+	dialog.w, dialog.h = w, h
+	dialog.title.Init(rsc.renderer, dialog.Pos, title, rsc.TitleFont, rsc.TitleColor)
+}
+
+// AddButton adds a button to the bottom row, laid out right to left
+func (dialog *Dialog) AddButton(text string, handler func()) *TextButton {
+	b := &TextButton{}
+	b.Init(dialog.rsc, sdl.Rect{0, 0, 72, DIALOG_BUTTON_HEIGHT}, text)
+	b.OnClick(handler)
+	dialog.buttons = append(dialog.buttons, b)
+	return b
+}
+
+// OnAccept sets the handler for the return key
+func (dialog *Dialog) OnAccept(handler func()) {
+	dialog.accept = handler
+}
+
+// OnCancel sets the handler for the escape key, which also closes the dialog
+func (dialog *Dialog) OnCancel(handler func()) {
+	dialog.cancel = handler
+}
+
+func (dialog *Dialog) Show() {
+	dialog.Visible = true
+}
+
+func (dialog *Dialog) Hide() {
+	dialog.Visible = false
+}
+
+func (dialog *Dialog) IsOpen() bool {
+	return dialog.Visible
+}
+
+// Body is the space between the title and the buttons
+func (dialog *Dialog) Body() sdl.Rect {
+	return sdl.Rect{dialog.Pos.X + DIALOG_INSET,
+		dialog.Pos.Y + DIALOG_TITLE_HEIGHT,
+		dialog.Pos.W - DIALOG_INSET*2,
+		dialog.Pos.H - DIALOG_TITLE_HEIGHT - DIALOG_BUTTON_HEIGHT - DIALOG_INSET*2}
+}
+
+// UpdateLayout centers the dialog in space, shrinking it to fit
+func (dialog *Dialog) UpdateLayout(space sdl.Rect) {
+	dialog.screen = space
+	dialog.Pos.W, dialog.Pos.H = dialog.w, dialog.h
+	if dialog.Pos.W > space.W - DIALOG_INSET*2 {
+		dialog.Pos.W = space.W - DIALOG_INSET*2
+	}
+	if dialog.Pos.H > space.H - DIALOG_INSET*2 {
+		dialog.Pos.H = space.H - DIALOG_INSET*2
+	}
+	dialog.Pos.X = space.X + (space.W - dialog.Pos.W) / 2
+	dialog.Pos.Y = space.Y + (space.H - dialog.Pos.H) / 2
+	dialog.title.Pos = sdl.Rect{dialog.Pos.X, dialog.Pos.Y, dialog.Pos.W, DIALOG_TITLE_HEIGHT}
+
+	x := dialog.Pos.X + dialog.Pos.W - DIALOG_INSET
+	y := dialog.Pos.Y + dialog.Pos.H - DIALOG_INSET - DIALOG_BUTTON_HEIGHT
+	for _, b := range dialog.buttons {
+		x -= b.Pos.W
+		b.Pos.X = x
+		b.Pos.Y = y
+		x -= DIALOG_INSET
+	}
+}
+
+func (dialog *Dialog) Draw(rend *sdl.Renderer) {
+	rend.SetDrawColor(sdl.Color{0, 0, 0, 0x60})
+	rend.FillRect(&dialog.screen)
+
+	rend.SetDrawColor(dialog.rsc.BackgroundColor)
+	rend.FillRect(&dialog.Pos)
+	rend.SetDrawColor(dialog.rsc.TitleBarColor)
+	rend.FillRect(&dialog.title.Pos)
+	rend.SetDrawColor(darken(dialog.rsc.TitleBarColor, 40))
+	rend.DrawRect(&dialog.Pos)
+	dialog.title.Draw(rend)
+	for _, b := range dialog.buttons {
+		b.Draw(rend)
+	}
+}
+
+func (dialog *Dialog) Destroy() {
+	dialog.title.Destroy()
+	for _, b := range dialog.buttons {
+		b.Destroy()
+	}
+}
+
+func (dialog *Dialog) OnMouseMotionEvent(event *sdl.MouseMotionEvent) bool {
+	for _, b := range dialog.buttons {
+		b.OnMouseMotionEvent(event)
+	}
+	return false
+}
+
+func (dialog *Dialog) OnMouseButtonEvent(event *sdl.MouseButtonEvent) bool {
+	for _, b := range dialog.buttons {
+		if !b.OnMouseButtonEvent(event) {
+			break
+		}
+	}
+	return false
+}
+
+func (dialog *Dialog) OnMouseWheelEvent(event *sdl.MouseWheelEvent) bool {
+	return false
+}
+
+func (dialog *Dialog) OnKeyboardEvent(event *sdl.KeyboardEvent) bool {
+	if event.State != sdl.PRESSED {
+		return false
+	}
+	switch event.Keysym.Keycode {
+	case sdl.K_ESCAPE:
+		if dialog.cancel != nil {
+			dialog.cancel()
+		}
+		dialog.Hide()
+	case sdl.K_RETURN:
+		if dialog.accept != nil {
+			dialog.accept()
+		}
+	}
+	return false
+}
+
+// TextDialog asks the user to type in a line of text
+type TextDialog struct {
+	Dialog
+	text Label
+	callback func(text string)
+}
+
+func (td *TextDialog) Init(rsc *Resources) {
+	td.Dialog.Init(rsc, " ", 320, DIALOG_TITLE_HEIGHT + ROW_HEIGHT + DIALOG_BUTTON_HEIGHT + DIALOG_INSET*4)
+	td.text.Init(rsc.renderer, td.Pos, " ", rsc.TitleFont, rsc.TitleColor)
+	td.AddButton("Cancel", func() {
+		td.Hide()
+	})
+	td.AddButton("OK", td.done)
+	td.OnAccept(td.done)
+}
+
+// Ask shows the dialog with the given title and starting text,
+// and calls callback with the text if the user accepts it
+func (td *TextDialog) Ask(title, text string, callback func(text string)) {
+	td.title.Text = title
+	td.title.Update(td.rsc.renderer)
+	td.setText(text)
+	td.callback = callback
+	td.Show()
+	sdl.StartTextInput()
+}
+
+func (td *TextDialog) setText(text string) {
+	td.text.Text = text
+	// a label can't render an empty string
+	if text == "" {
+		td.text.Text = " "
+	}
+	td.text.Update(td.rsc.renderer)
+	td.text.Text = text
+}
+
+func (td *TextDialog) done() {
+	if td.text.Text == "" {
+		return
+	}
+	td.Hide()
+	if td.callback != nil {
+		td.callback(td.text.Text)
+	}
+}
+
+func (td *TextDialog) Hide() {
+	td.Dialog.Hide()
+	sdl.StopTextInput()
+}
+
+func (td *TextDialog) Draw(rend *sdl.Renderer) {
+	td.Dialog.Draw(rend)
+	body := td.Body()
+	field := sdl.Rect{body.X, body.Y + DIALOG_INSET, body.W, ROW_HEIGHT + 4}
+	rend.SetDrawColor(lighten(td.rsc.BackgroundColor, 17))
+	rend.FillRect(&field)
+	rend.SetDrawColor(darken(td.rsc.TitleBarColor, 20))
+	rend.DrawRect(&field)
+	w := td.text.texwidth
+	if td.text.Text == "" {
+		w = 0
+	}
+	td.text.Pos = sdl.Rect{field.X + 4, field.Y + 2, w, ROW_HEIGHT}
+	td.text.Draw(rend)
+	// cursor
+	rend.SetDrawColor(td.rsc.TitleColor)
+	rend.DrawLine(field.X + 5 + w, field.Y + 3, field.X + 5 + w, field.Y + field.H - 3)
+}
+
+func (td *TextDialog) Destroy() {
+	td.Dialog.Destroy()
+	td.text.Destroy()
+}
+
+func (td *TextDialog) OnTextInputEvent(event *sdl.TextInputEvent) bool {
+	text := string(bytes.TrimRight(event.Text[:], "\x00"))
+	td.setText(td.text.Text + text)
+	return false
+}
+
+func (td *TextDialog) OnKeyboardEvent(event *sdl.KeyboardEvent) bool {
+	if event.State == sdl.PRESSED && event.Keysym.Keycode == sdl.K_BACKSPACE {
+		runes := []rune(td.text.Text)
+		if len(runes) > 0 {
+			td.setText(string(runes[:len(runes) - 1]))
+		}
+		return false
+	}
+	if event.State == sdl.PRESSED && event.Keysym.Keycode == sdl.K_ESCAPE {
+		td.Hide()
+		return false
+	}
+	return td.Dialog.OnKeyboardEvent(event)
+}
+
+const FORM_LABEL_WIDTH = int32(88)
+
+// formField is one labelled line of text in a FormDialog
+type formField struct {
+	name Label
+	text Label
+	field sdl.Rect
+}
+
+// FormDialog asks for several lines of text at once. Tab or a click
+// moves between them.
+type FormDialog struct {
+	Dialog
+	fields []*formField
+	focus int
+	callback func(values []string)
+}
+
+func (fd *FormDialog) Init(rsc *Resources) {
+	fd.Dialog.Init(rsc, " ", 480, 0)
+	fd.AddButton("Cancel", func() {
+		fd.Hide()
+	})
+	fd.AddButton("OK", fd.done)
+	fd.OnAccept(fd.done)
+}
+
+// Ask shows the dialog with a field for each name, filled in with the
+// values, and calls callback with what they hold if the user accepts
+func (fd *FormDialog) Ask(title string, names, values []string, callback func(values []string)) {
+	fd.title.Text = title
+	fd.title.Update(fd.rsc.renderer)
+	for _, f := range fd.fields {
+		f.name.Destroy()
+		f.text.Destroy()
+	}
+	fd.fields = nil
+	for i, name := range names {
+		f := &formField{}
+		f.name.Init(fd.rsc.renderer, fd.Pos, name, fd.rsc.TitleFont, fd.rsc.TitleColor)
+		f.text.Init(fd.rsc.renderer, fd.Pos, " ", fd.rsc.TitleFont, fd.rsc.TitleColor)
+		fd.fields = append(fd.fields, f)
+		fd.setText(i, values[i])
+	}
+	fd.focus = 0
+	fd.h = DIALOG_TITLE_HEIGHT + int32(len(names)) * (ROW_HEIGHT + 4 + DIALOG_INSET) + DIALOG_BUTTON_HEIGHT + DIALOG_INSET*3
+	fd.callback = callback
+	fd.Show()
+	fd.UpdateLayout(fd.screen)
+	sdl.StartTextInput()
+}
+
+func (fd *FormDialog) setText(i int, text string) {
+	label := &fd.fields[i].text
+	label.Text = text
+	if text == "" {
+		label.Text = " "
+	}
+	label.Update(fd.rsc.renderer)
+	label.Text = text
+}
+
+func (fd *FormDialog) done() {
+	fd.Hide()
+	if fd.callback != nil {
+		var values []string
+		for _, f := range fd.fields {
+			values = append(values, f.text.Text)
+		}
+		fd.callback(values)
+	}
+}
+
+func (fd *FormDialog) Hide() {
+	fd.Dialog.Hide()
+	sdl.StopTextInput()
+}
+
+func (fd *FormDialog) UpdateLayout(space sdl.Rect) {
+	fd.Dialog.UpdateLayout(space)
+	body := fd.Body()
+	y := body.Y + DIALOG_INSET
+	for _, f := range fd.fields {
+		f.name.Pos = sdl.Rect{body.X, y + 2, f.name.texwidth, ROW_HEIGHT}
+		f.field = sdl.Rect{body.X + FORM_LABEL_WIDTH, y, body.W - FORM_LABEL_WIDTH, ROW_HEIGHT + 4}
+		y += ROW_HEIGHT + 4 + DIALOG_INSET
+	}
+}
+
+func (fd *FormDialog) Draw(rend *sdl.Renderer) {
+	fd.Dialog.Draw(rend)
+	for i, f := range fd.fields {
+		f.name.Draw(rend)
+		rend.SetDrawColor(lighten(fd.rsc.BackgroundColor, 17))
+		rend.FillRect(&f.field)
+		rend.SetDrawColor(darken(fd.rsc.TitleBarColor, 20))
+		if i == fd.focus {
+			rend.SetDrawColor(fd.rsc.TitleColor)
+		}
+		rend.DrawRect(&f.field)
+		w := f.text.texwidth
+		if f.text.Text == "" {
+			w = 0
+		}
+		// long text shows its end, where the typing is
+		x := f.field.X + 4
+		if over := w - (f.field.W - 10); over > 0 {
+			x -= over
+		}
+		f.text.Pos = sdl.Rect{x, f.field.Y + 2, w, ROW_HEIGHT}
+		rend.SetClipRect(&f.field)
+		f.text.Draw(rend)
+		rend.SetClipRect(nil)
+		if i == fd.focus {
+			rend.SetDrawColor(fd.rsc.TitleColor)
+			rend.DrawLine(x + 1 + w, f.field.Y + 3, x + 1 + w, f.field.Y + f.field.H - 3)
+		}
+	}
+}
+
+func (fd *FormDialog) Destroy() {
+	fd.Dialog.Destroy()
+	for _, f := range fd.fields {
+		f.name.Destroy()
+		f.text.Destroy()
+	}
+}
+
+func (fd *FormDialog) OnMouseButtonEvent(event *sdl.MouseButtonEvent) bool {
+	if event.State == sdl.PRESSED && event.Button == sdl.BUTTON_LEFT {
+		for i, f := range fd.fields {
+			if f.field.Contains(event.X, event.Y) {
+				fd.focus = i
+				return false
+			}
+		}
+	}
+	return fd.Dialog.OnMouseButtonEvent(event)
+}
+
+func (fd *FormDialog) OnTextInputEvent(event *sdl.TextInputEvent) bool {
+	if len(fd.fields) == 0 {
+		return false
+	}
+	text := string(bytes.TrimRight(event.Text[:], "\x00"))
+	fd.setText(fd.focus, fd.fields[fd.focus].text.Text + text)
+	return false
+}
+
+func (fd *FormDialog) OnKeyboardEvent(event *sdl.KeyboardEvent) bool {
+	if event.State != sdl.PRESSED || len(fd.fields) == 0 {
+		return fd.Dialog.OnKeyboardEvent(event)
+	}
+	switch event.Keysym.Keycode {
+	case sdl.K_BACKSPACE:
+		runes := []rune(fd.fields[fd.focus].text.Text)
+		if len(runes) > 0 {
+			fd.setText(fd.focus, string(runes[:len(runes) - 1]))
+		}
+		return false
+	case sdl.K_TAB, sdl.K_DOWN:
+		step := 1
+		if event.Keysym.Keycode == sdl.K_TAB && sdl.GetModState() & sdl.KMOD_SHIFT != 0 {
+			step = -1
+		}
+		fd.focus = (fd.focus + step + len(fd.fields)) % len(fd.fields)
+		return false
+	case sdl.K_UP:
+		fd.focus = (fd.focus + len(fd.fields) - 1) % len(fd.fields)
+		return false
+	case sdl.K_ESCAPE:
+		fd.Hide()
+		return false
+	}
+	return fd.Dialog.OnKeyboardEvent(event)
+}
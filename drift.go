@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+)
+
+const (
+	// how much is compared at each end of the tracks, in seconds
+	DRIFT_WINDOW = 30
+	// how long the tracks have to play together to measure drift
+	DRIFT_MIN_SPAN = 300
+)
+
+// drift is how a track wanders away from the reference: ahead of it by
+// early seconds at time at, and by slope seconds more every second
+type drift struct {
+	node *Node
+	key string
+	at, early, slope float64
+	confidence float64
+}
+
+// lastEnd returns where the last clip of a track ends
+func lastEnd(clips []Clip) float64 {
+	end := 0.0
+	for _, c := range clips {
+		end = math.Max(end, c.End())
+	}
+	return end
+}
+
+// measureDrift compares a window at the start and one at the end of
+// where the tracks play together
+func measureDrift(ref, t *track) (drift, error) {
+	from := math.Max(firstStart(ref.clips), firstStart(t.clips))
+	to := math.Min(lastEnd(ref.clips), lastEnd(t.clips))
+	if to - from < DRIFT_MIN_SPAN {
+		return drift{}, fmt.Errorf("tracks play together for %.0fs, need %ds to measure drift", to - from, DRIFT_MIN_SPAN)
+	}
+	rate := t.format.Rate
+	k0, c0 := lag(readMono(ref, from, DRIFT_WINDOW), readMono(t, from, DRIFT_WINDOW), rate)
+	k1, c1 := lag(readMono(ref, to - DRIFT_WINDOW, DRIFT_WINDOW), readMono(t, to - DRIFT_WINDOW, DRIFT_WINDOW), rate)
+	span := to - from - DRIFT_WINDOW
+	return drift{
+		key: t.key,
+		at: from + DRIFT_WINDOW / 2,
+		early: float64(k0) / rate,
+		slope: float64(k1 - k0) / rate / span,
+		confidence: math.Min(c0, c1),
+	}, nil
+}
+
+// correct moves every clip to where it lines up with the reference
+// and slows it down or speeds it up to stay there. The clips still
+// play the same part of their files, so they stretch on the timeline
+// along with their fades, and split clips still meet.
+func (d drift) correct(clips []Clip) {
+	stretch := 1 + d.slope
+	for i := range clips {
+		c := &clips[i]
+		c.Start += d.early + (c.Start - d.at) * d.slope
+		c.Speed = c.speed() / stretch
+		c.Length *= stretch
+		c.FadeIn *= stretch
+		c.FadeOut *= stretch
+	}
+}
+
+// CorrectDrift measures the drift of an input against the reference
+// track in the background, correcting its clips when done
+func (canvas *CanvasPane) CorrectDrift(n *Node) {
+	ref := canvas.reference
+	switch {
+	case ref == nil:
+		log.Println("Pick a reference track first.")
+		return
+	case ref == n:
+		return
+	case ref.format.Rate != n.format.Rate:
+		log.Println("Can't compare tracks at different sample rates.")
+		return
+	}
+	r, t := ref.snapshot(), n.snapshot()
+	log.Println("Measuring drift of", n.label.Text, "against", ref.label.Text)
+	go func() {
+		d, err := measureDrift(r, t)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		d.node = n
+		canvas.drifts <- d
+	}()
+}
+
+// UpdateDrifts corrects the tracks whose drift has been measured,
+// unless they were edited in the meantime
+func (canvas *CanvasPane) UpdateDrifts() {
+	for {
+		select {
+		case d := <-canvas.drifts:
+			if d.node.clipsKey() != d.key {
+				log.Println(d.node.label.Text, "changed while measuring drift, try again.")
+				continue
+			}
+			d.correct(d.node.clips)
+			d.node.aligned = d.confidence
+			log.Printf("Corrected %s drifting %.0f ppm, %.0f%% confident\n", d.node.label.Text, d.slope * 1e6, d.confidence * 100)
+		default:
+			return
+		}
+	}
+}
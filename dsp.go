@@ -0,0 +1,207 @@
+package main
+
+import (
+	"math"
+)
+
+// the effects with a native processor
+var nativeEffects = map[string]func(node *Node, params []*Param, format Format) Processor{
+	"vol": func(node *Node, params []*Param, format Format) Processor {
+		return &volProcessor{params[0]}
+	},
+	"highpass": func(node *Node, params []*Param, format Format) Processor {
+		return newBiquad(format, params, func(rate float64, p []*Param) biquadCoeffs {
+			return highpassCoeffs(rate, p[0].Get(), math.Sqrt2 / 2)
+		})
+	},
+	"lowpass": func(node *Node, params []*Param, format Format) Processor {
+		return newBiquad(format, params, func(rate float64, p []*Param) biquadCoeffs {
+			return lowpassCoeffs(rate, p[0].Get(), math.Sqrt2 / 2)
+		})
+	},
+	"equalizer": func(node *Node, params []*Param, format Format) Processor {
+		return newBiquad(format, params, func(rate float64, p []*Param) biquadCoeffs {
+			return peakingCoeffs(rate, p[0].Get(), p[1].Get(), p[2].Get())
+		})
+	},
+	"compand": func(node *Node, params []*Param, format Format) Processor {
+		return &compressor{params: params, rate: format.Rate}
+	},
+	"delay": func(node *Node, params []*Param, format Format) Processor {
+		return &delayLine{param: params[0], rate: format.Rate}
+	},
+	"denoise": newDenoiser,
+	"deess": newDeesser,
+	"deplosive": newDeplosive,
+	"declick": newDeclicker,
+	"declip": newDeclipper,
+}
+
+// newProcessor returns the processor for an effect node, native if
+// there is one, otherwise the SoX effect wrapped up
+func newProcessor(node *Node, params []*Param, format Format) Processor {
+	if build, ok := nativeEffects[node.effect]; ok {
+		return build(node, params, format)
+	}
+	return newSoxProcessor(node.effect, node.EffectArgs(), format)
+}
+
+type volProcessor struct {
+	gain *Param
+}
+
+func (v *volProcessor) Process(block *Block) {
+	g := float32(dbToGain(v.gain.Get()))
+	data := block.Samples()
+	for i := range data {
+		data[i] *= g
+	}
+}
+
+// biquadCoeffs are normalized so a0 is 1
+type biquadCoeffs struct {
+	b0, b1, b2, a1, a2 float64
+}
+
+// filter coefficients from the Audio EQ Cookbook
+func highpassCoeffs(rate, freq, q float64) biquadCoeffs {
+	w := 2 * math.Pi * math.Min(freq, rate * 0.49) / rate
+	alpha := math.Sin(w) / (2 * q)
+	cos := math.Cos(w)
+	a0 := 1 + alpha
+	return biquadCoeffs{(1 + cos) / 2 / a0, -(1 + cos) / a0, (1 + cos) / 2 / a0, -2 * cos / a0, (1 - alpha) / a0}
+}
+
+func lowpassCoeffs(rate, freq, q float64) biquadCoeffs {
+	w := 2 * math.Pi * math.Min(freq, rate * 0.49) / rate
+	alpha := math.Sin(w) / (2 * q)
+	cos := math.Cos(w)
+	a0 := 1 + alpha
+	return biquadCoeffs{(1 - cos) / 2 / a0, (1 - cos) / a0, (1 - cos) / 2 / a0, -2 * cos / a0, (1 - alpha) / a0}
+}
+
+func peakingCoeffs(rate, freq, q, gain float64) biquadCoeffs {
+	w := 2 * math.Pi * math.Min(freq, rate * 0.49) / rate
+	alpha := math.Sin(w) / (2 * q)
+	cos := math.Cos(w)
+	a := math.Pow(10, gain / 40)
+	a0 := 1 + alpha / a
+	return biquadCoeffs{(1 + alpha * a) / a0, -2 * cos / a0, (1 - alpha * a) / a0, -2 * cos / a0, (1 - alpha / a) / a0}
+}
+
+// biquad is a second order filter, recalculated when its
+// parameters change
+type biquad struct {
+	rate float64
+	params []*Param
+	design func(rate float64, p []*Param) biquadCoeffs
+	last []float64
+	c biquadCoeffs
+	// per channel state
+	x1, x2, y1, y2 []float64
+}
+
+func newBiquad(format Format, params []*Param, design func(rate float64, p []*Param) biquadCoeffs) *biquad {
+	return &biquad{
+		rate: format.Rate,
+		params: params,
+		design: design,
+		x1: make([]float64, format.Channels),
+		x2: make([]float64, format.Channels),
+		y1: make([]float64, format.Channels),
+		y2: make([]float64, format.Channels),
+	}
+}
+
+func (f *biquad) update() {
+	changed := len(f.last) != len(f.params)
+	if changed {
+		f.last = make([]float64, len(f.params))
+	}
+	for i, p := range f.params {
+		if v := p.Get(); v != f.last[i] {
+			f.last[i] = v
+			changed = true
+		}
+	}
+	if changed {
+		f.c = f.design(f.rate, f.params)
+	}
+}
+
+func (f *biquad) Process(block *Block) {
+	f.update()
+	c := f.c
+	data := block.Samples()
+	for i := 0; i < len(data); i += block.Channels {
+		for ch := 0; ch < block.Channels; ch++ {
+			x := float64(data[i + ch])
+			y := c.b0 * x + c.b1 * f.x1[ch] + c.b2 * f.x2[ch] - c.a1 * f.y1[ch] - c.a2 * f.y2[ch]
+			f.x2[ch], f.x1[ch] = f.x1[ch], x
+			f.y2[ch], f.y1[ch] = f.y1[ch], y
+			data[i + ch] = float32(y)
+		}
+	}
+}
+
+// compressor follows the loudest channel and turns down everything
+// above the threshold by the ratio, like the compand arguments do
+type compressor struct {
+	params []*Param
+	rate float64
+	env float64
+}
+
+func (c *compressor) Process(block *Block) {
+	attack := math.Exp(-1 / (c.params[0].Get() / 1000 * c.rate))
+	release := math.Exp(-1 / (c.params[1].Get() / 1000 * c.rate))
+	thr := c.params[2].Get()
+	ratio := c.params[3].Get()
+	data := block.Samples()
+	for i := 0; i < len(data); i += block.Channels {
+		peak := 0.0
+		for ch := 0; ch < block.Channels; ch++ {
+			peak = math.Max(peak, math.Abs(float64(data[i + ch])))
+		}
+		coeff := release
+		if peak > c.env {
+			coeff = attack
+		}
+		c.env = coeff * c.env + (1 - coeff) * peak
+		gain := 1.0
+		if level := gainToDb(c.env); level > thr {
+			gain = dbToGain((thr - level) * (1 - 1 / ratio))
+		}
+		for ch := 0; ch < block.Channels; ch++ {
+			data[i + ch] *= float32(gain)
+		}
+	}
+}
+
+// delayLine holds the signal back by a number of milliseconds
+type delayLine struct {
+	param *Param
+	rate float64
+	line []float32
+	pos int
+}
+
+func (d *delayLine) Process(block *Block) {
+	size := int(d.param.Get() / 1000 * d.rate) * block.Channels
+	if size == 0 {
+		d.line = nil
+		return
+	}
+	if len(d.line) != size {
+		d.line = make([]float32, size)
+		d.pos = 0
+	}
+	data := block.Samples()
+	for i := range data {
+		data[i], d.line[d.pos] = d.line[d.pos], data[i]
+		d.pos++
+		if d.pos == size {
+			d.pos = 0
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"math"
+)
+
+// a ducker mixes its inputs like a mixer, but everything not marked
+// as a key is turned down while the keys are speaking
+var duckerParams = []ParamSpec{
+	{"amount", UNIT_DB, -40, 0, -12, false},
+	{"threshold", UNIT_DB, -60, 0, -40, false},
+	{"lookahead", UNIT_MS, 0, 200, 20, false},
+	{"attack", UNIT_MS, 1, 1000, 80, true},
+	{"release", UNIT_MS, 10, 5000, 600, true},
+}
+
+// how fast the key level follows the speech, in milliseconds
+const (
+	KEY_ATTACK_MS = 1
+	KEY_RELEASE_MS = 50
+)
+
+// mixes is true for nodes that mix their inputs, which are panned
+// into stereo on the way in
+func (node *Node) mixes() bool {
+	return node.name == "mixer" || node.name == "ducker" || node.name == "automix"
+}
+
+func (canvas *CanvasPane) newDucker(x, y int32) *Node {
+	n := canvas.newNode("ducker", "ducker", hexcolor(0x694ae9), x, y)
+	n.params = makeParams(duckerParams)
+	canvas.mixerMenu(n)
+	return n
+}
+
+// ducker turns the music down under the keys. The output is held
+// back by the lookahead, so the music is already down when the
+// speech starts. The lookahead is read when the ducker is made, as
+// the graph is built around it.
+type ducker struct {
+	params []*Param
+	rate float64
+	// the key level and the gain of the music, both linear
+	level float64
+	gain float64
+	// the delayed music and keys, interleaved
+	music, keys []float32
+	pos int
+	frames int
+}
+
+func newDucker(params []*Param, format Format) *ducker {
+	frames := int(params[2].Get() / 1000 * format.Rate)
+	return &ducker{
+		params: params,
+		rate: format.Rate,
+		gain: 1,
+		music: make([]float32, frames * format.Channels),
+		keys: make([]float32, frames * format.Channels),
+		frames: frames,
+	}
+}
+
+// Latency returns the lookahead in frames
+func (d *ducker) Latency() int {
+	return d.frames
+}
+
+// coeff returns the one pole filter coefficient for a time in ms
+func (d *ducker) coeff(ms float64) float64 {
+	return math.Exp(-1 / (ms / 1000 * d.rate))
+}
+
+// Duck mixes the keys into the music, turning the music down while
+// the keys are above the threshold. The result is left in music.
+func (d *ducker) Duck(music, keys *Block) {
+	music.Extend(keys.Frames)
+	keys.Extend(music.Frames)
+	ch := music.Channels
+	size := len(d.music)
+	amount := dbToGain(d.params[0].Get())
+	threshold := dbToGain(d.params[1].Get())
+	attack, release := d.coeff(d.params[3].Get()), d.coeff(d.params[4].Get())
+	keyAttack, keyRelease := d.coeff(KEY_ATTACK_MS), d.coeff(KEY_RELEASE_MS)
+
+	data, key := music.Samples(), keys.Samples()
+	for i := 0; i < len(data); i += ch {
+		peak := 0.0
+		for c := i; c < i + ch; c++ {
+			peak = math.Max(peak, math.Abs(float64(key[c])))
+		}
+		if peak > d.level {
+			d.level = keyAttack * d.level + (1 - keyAttack) * peak
+		} else {
+			d.level = keyRelease * d.level + (1 - keyRelease) * peak
+		}
+		target := 1.0
+		if d.level > threshold {
+			target = amount
+		}
+		if target < d.gain {
+			d.gain = attack * d.gain + (1 - attack) * target
+		} else {
+			d.gain = release * d.gain + (1 - release) * target
+		}
+		g := float32(d.gain)
+		for c := i; c < i + ch; c++ {
+			m, k := data[c], key[c]
+			if size > 0 {
+				m, d.music[d.pos] = d.music[d.pos], m
+				k, d.keys[d.pos] = d.keys[d.pos], k
+				d.pos = (d.pos + 1) % size
+			}
+			data[c] = m * g + k
+		}
+	}
+}
@@ -0,0 +1,834 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync/atomic"
+
+	"github.com/krig/go-sox"
+)
+
+const (
+	// how long switching from one graph to another takes
+	XFADE_MS = 30
+	// blocks a voice remembers, for graphs a little behind the others
+	VOICE_HISTORY = 4
+)
+
+// puller is a stage or anything else blocks can be pulled through
+type puller interface {
+	pull(e *Engine, g *graph) *Block
+	prepare(e *Engine)
+	close()
+	// how many seconds behind the timeline what it returns is, known
+	// once it is prepared
+	latency() float64
+}
+
+// graph is a snapshot of the links on the canvas, taken on the UI
+// thread for the engine to play. It only goes back to the nodes for
+// their parameters and switches, which are safe to read while playing.
+type graph struct {
+	inputs []*Node
+	tracks map[*Node]*track
+	out *stage
+	// the output, converted to the format of the sound card
+	final puller
+	// set by the engine before each block
+	solo bool
+	// seconds into the timeline at the start of the block
+	time float64
+	// voices opened on the UI thread for the tracks the engine had
+	// no voice for, by track key
+	voices map[string]*voice
+}
+
+// track is a snapshot of the clips on an input. Its key tells the
+// engine when a voice can be shared with the graph before.
+type track struct {
+	key string
+	format Format
+	clips []Clip
+}
+
+// stage is a node in a graph. Pulling a block from it pulls from
+// everything linked to it, mixes that and runs it through the
+// node's processor.
+type stage struct {
+	node *Node
+	// the clips of an input
+	track *track
+	inputs []puller
+	format Format
+	// what the inputs are converted to, only differs for routers
+	accept Format
+	// the channels picked by a router
+	mode string
+	// the next block to pull from the voice of an input
+	index int
+	pan panState
+	proc Processor
+	// copies of the node's parameters, set from its envelopes as the
+	// graph plays, for the processor, ducker or automix to read
+	params []*Param
+	// the next values of params, while running an automated effect
+	next []float64
+	wet Ramp
+	block *Block
+	dry *Block
+	// holds the dry signal back as long as the processor holds back
+	// the wet, so bypassing doesn't jump
+	dryLag lagLine
+	// the part of the block processed while params stay the same
+	part Block
+	// what goes into an input or router before panning or routing
+	mix *Block
+	// whether the stage carries a key, and which inputs of a ducker
+	// are keys, mixed into keys
+	key bool
+	keyed []bool
+	keys *Block
+	duck *ducker
+	// the inputs of an automix, mixed by auto
+	parts []*Block
+	auto *automixer
+	// how late the inputs arrive and the output leaves, in seconds
+	late, lag float64
+}
+
+// voice plays the clips of one track. Graphs pull blocks by number,
+// so the old and new graph in a crossfade hear the same audio.
+type voice struct {
+	clips []*clipReader
+	format Format
+	// the frame of the timeline block 0 starts at, and where the
+	// last clip ends
+	start uint64
+	end int64
+	ramp Ramp
+	blocks [VOICE_HISTORY]*Block
+	read int
+	silence *Block
+	// frames of silence played after the end, to push out what the
+	// effects are holding back
+	tail int64
+}
+
+// Engine plays a graph of inputs, effects and mixers to the sound
+// card, pulling one block at a time through it. Parameters and
+// switches are read every block, and a new graph replaces the old
+// one with a short crossfade.
+type Engine struct {
+	out *sox.Format
+	format Format
+	// by track key
+	voices map[string]*voice
+	graph *graph
+	// the graph being faded out
+	old *graph
+	fade Ramp
+	pending chan *graph
+	// the last graph handed over, only touched on the UI thread
+	handed *graph
+	// frames pulled through the graph so far, and how many of them
+	// the graph holds back, read by the UI for the playhead
+	position uint64
+	lag uint64
+	// frames still to be dropped from the start of the output, the
+	// silence from before the timeline started
+	skip int
+	// playing to the sound card, where nothing may wait
+	realtime bool
+	// set by the UI and by Flow, read by the other, atomically
+	interrupt int32
+	finished int32
+}
+
+// topologyKey describes everything about the canvas that needs a
+// new graph when it changes
+func (canvas *CanvasPane) topologyKey() string {
+	var key strings.Builder
+	for _, n := range canvas.nodes {
+		next := 0
+		if n.next != nil {
+			next = n.next.id
+		}
+		fmt.Fprintf(&key, "%d>%d:%s:%s:%v:%p:%s:%s;", n.id, next, n.effect, strings.Join(n.args, ","), n.key.Get(), n.profile, n.holdsBack(), n.clipsKey())
+	}
+	return key.String()
+}
+
+// holdsBack describes the parameters that set how long a node holds
+// its signal back, which the graph is lined up around
+func (node *Node) holdsBack() string {
+	if node.name == "ducker" {
+		return fmt.Sprint(node.params[2].Get())
+	}
+	return ""
+}
+
+// clipsKey describes the clips on an input
+func (node *Node) clipsKey() string {
+	var key strings.Builder
+	for _, c := range node.clips {
+		fmt.Fprintf(&key, "%v|", c)
+	}
+	return key.String()
+}
+
+// buildGraph takes a snapshot of the inputs that reach the first
+// output and everything on the way there
+func (canvas *CanvasPane) buildGraph() *graph {
+	g := &graph{tracks: make(map[*Node]*track)}
+	stages := make(map[*Node]*stage)
+	var stop *Node
+	for _, n := range canvas.nodes {
+		if n.name != "input" || len(n.clips) == 0 {
+			continue
+		}
+		path := pathFrom(n)
+		if path == nil {
+			continue
+		}
+		if stop == nil {
+			stop = path[len(path) - 1]
+		}
+		if path[len(path) - 1] != stop {
+			continue
+		}
+		g.inputs = append(g.inputs, n)
+		t := &track{
+			key: fmt.Sprintf("%d:%s", n.id, n.clipsKey()),
+			format: n.format,
+			clips: crossfades(n.clips),
+		}
+		g.tracks[n] = t
+		prev := &stage{node: n, track: t}
+		for _, m := range path {
+			s, seen := stages[m]
+			if !seen {
+				s = &stage{node: m}
+				stages[m] = s
+			}
+			s.inputs = append(s.inputs, prev)
+			if seen {
+				break
+			}
+			prev = s
+		}
+	}
+	if stop == nil {
+		return nil
+	}
+	g.out = stages[stop]
+	g.out.negotiate(canvas.linkFormats())
+	return g
+}
+
+// NewEngine opens the output device to play the graph
+func NewEngine(g *graph) *Engine {
+	return newEngine(g, "default", "alsa", nil)
+}
+
+// NewRender plays the graph into a file instead, as fast as it can.
+// The file type comes from the extension.
+func NewRender(g *graph, path string) *Engine {
+	return newEngine(g, path, "", nil)
+}
+
+// NewEncode renders into a file of a given type and encoding
+func NewEncode(g *graph, path, filetype string, encoding *sox.EncodingInfo) *Engine {
+	return newEngine(g, path, filetype, encoding)
+}
+
+func newEngine(g *graph, path, filetype string, encoding *sox.EncodingInfo) *Engine {
+	e := &Engine{voices: make(map[string]*voice), pending: make(chan *graph, 1), handed: g, realtime: filetype == "alsa"}
+	for _, n := range g.inputs {
+		t := g.tracks[n]
+		if v := openVoice(t); v != nil {
+			e.voices[t.key] = v
+		}
+	}
+	if len(e.voices) == 0 || g.out.format.Rate == 0 {
+		e.Release()
+		return nil
+	}
+	e.format = g.out.format
+	signal := sox.NewSignalInfo(e.format.Rate, uint(e.format.Channels), OUTPUT_BITS, 0, nil)
+	defer signal.Release()
+	e.out = sox.OpenWrite(path, signal, encoding, filetype)
+	if e.out == nil {
+		log.Println("Failed to open output:", path)
+		e.Release()
+		return nil
+	}
+	e.adopt(g)
+	return e
+}
+
+// openVoice opens the clips of a track, or returns nil when none of
+// them can be played. Opening files takes a while, so it is done
+// before a graph is handed to the engine, never while it plays.
+func openVoice(t *track) *voice {
+	if t.format.Rate == 0 {
+		return nil
+	}
+	v := &voice{format: t.format}
+	for _, c := range t.clips {
+		if r := openClip(c, t.format); r != nil {
+			v.clips = append(v.clips, r)
+			if end := r.frames.start + r.frames.length; end > v.end {
+				v.end = end
+			}
+		}
+	}
+	if len(v.clips) == 0 {
+		return nil
+	}
+	return v
+}
+
+func (v *voice) Release() {
+	for _, c := range v.clips {
+		c.Release()
+	}
+}
+
+// Rebuild hands the engine a new graph to fade over to, opening the
+// tracks the graph before didn't have. A graph still waiting is
+// replaced, passing on the voices opened for it.
+func (e *Engine) Rebuild(g *graph) {
+	var skipped *graph
+	select {
+	case skipped = <-e.pending:
+	default:
+	}
+	g.voices = make(map[string]*voice)
+	for _, t := range g.tracks {
+		if skipped != nil && skipped.voices[t.key] != nil {
+			g.voices[t.key] = skipped.voices[t.key]
+			delete(skipped.voices, t.key)
+		} else if !e.handed.plays(t.key) {
+			if v := openVoice(t); v != nil {
+				g.voices[t.key] = v
+			}
+		}
+	}
+	if skipped != nil {
+		skipped.releaseVoices()
+	}
+	e.handed = g
+	e.pending <- g
+}
+
+// plays is true when the graph has a track with a key
+func (g *graph) plays(key string) bool {
+	for _, t := range g.tracks {
+		if t.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseVoices closes the voices opened for a graph the engine
+// never took
+func (g *graph) releaseVoices() {
+	for _, v := range g.voices {
+		v.Release()
+	}
+	g.voices = nil
+}
+
+// adopt starts playing a new graph, fading out the current one. It
+// is only called once the last crossfade has finished.
+func (e *Engine) adopt(g *graph) {
+	solo := soloing(g.inputs)
+	for key, v := range g.voices {
+		if e.voices[key] != nil {
+			v.Release()
+			continue
+		}
+		v.start = uint64(e.time() * v.format.Rate)
+		e.voices[key] = v
+	}
+	g.voices = nil
+	for _, n := range g.inputs {
+		t := g.tracks[n]
+		if v := e.voices[t.key]; v != nil && v.silence == nil {
+			for i := range v.blocks {
+				v.blocks[i] = NewBlock(v.format)
+			}
+			v.silence = NewBlock(v.format)
+			v.silence.Clear(BLOCK_SIZE)
+			v.ramp.Init(v.format.Rate, RAMP_MS, inputGain(n, solo, e.time()))
+		}
+	}
+	g.final = g.out
+	if g.out.format != e.format {
+		g.final = newConverter(g.out, g.out.format, e.format)
+	}
+	g.final.prepare(e)
+	lag := g.final.latency()
+	for _, v := range e.voices {
+		if tail := int64(math.Ceil(lag * v.format.Rate)); tail > v.tail {
+			v.tail = tail
+		}
+	}
+	if e.position == 0 {
+		e.skip = int(math.Floor(lag * e.format.Rate + 0.5))
+	}
+	atomic.StoreUint64(&e.lag, uint64(math.Floor(lag * e.format.Rate + 0.5)))
+	e.old = e.graph
+	e.graph = g
+	e.fade.Init(e.format.Rate, XFADE_MS, 0)
+}
+
+// prepare sets up the processors of a stage and everything upstream,
+// delaying the inputs that arrive ahead of the others
+func (s *stage) prepare(e *Engine) {
+	format := s.format
+	s.mix = nil
+	s.params = copyParams(s.node.params)
+	s.next = make([]float64, len(s.params))
+	if v := s.voice(e); v != nil {
+		format = v.format
+		s.index = v.read
+		if canPan(format) {
+			s.mix = NewBlock(format)
+			format = Format{format.Rate, 2}
+		}
+	} else if s.node.name == "router" {
+		s.mix = NewBlock(s.accept)
+	} else if s.node.name == "ducker" {
+		s.keys = NewBlock(format)
+		s.duck = newDucker(s.params, format)
+	} else if s.node.name == "automix" {
+		s.auto = newAutomixer(s.params, format)
+	}
+	s.block = NewBlock(format)
+	if s.node.name == "effect" && s.node.effect != "" {
+		s.proc = newProcessor(s.node, s.params, format)
+		if s.proc == nil {
+			log.Println("Effect not available:", s.node.effect)
+		}
+		if sp, soxed := s.proc.(*soxProcessor); soxed {
+			sp.waits = !e.realtime
+			if s.node.automated() {
+				log.Println("SoX effects can't be automated, ignoring the envelopes of", s.node.label.Text)
+			}
+		}
+		s.dry = NewBlock(format)
+		s.dryLag = newLagLine(latencyOf(s.proc) * format.Channels)
+		wet := 1.0
+		if s.node.bypass.Get() {
+			wet = 0
+		}
+		s.wet.Init(format.Rate, RAMP_MS, wet)
+	}
+	for _, in := range s.inputs {
+		in.prepare(e)
+	}
+	s.late = 0
+	for _, in := range s.inputs {
+		s.late = math.Max(s.late, in.latency())
+	}
+	for i, in := range s.inputs {
+		if d := s.late - in.latency(); d > 0 {
+			s.inputs[i] = newDelay(in, d)
+		}
+	}
+	held := latencyOf(s.proc)
+	if s.duck != nil {
+		held += s.duck.Latency()
+	}
+	s.lag = s.late + float64(held) / format.Rate
+}
+
+func (s *stage) latency() float64 {
+	return s.lag
+}
+
+// delay holds back whatever is pulled through it by a number of
+// seconds, to line it up with something that arrives later
+type delay struct {
+	src puller
+	seconds float64
+	block *Block
+	// the frames held back, interleaved
+	fifo []float32
+}
+
+func newDelay(src puller, seconds float64) *delay {
+	return &delay{src: src, seconds: seconds}
+}
+
+func (d *delay) pull(e *Engine, g *graph) *Block {
+	b := d.src.pull(e, g)
+	if d.block == nil {
+		d.block = NewBlock(b.Format)
+		d.fifo = make([]float32, int(math.Floor(d.seconds * b.Rate + 0.5)) * b.Channels)
+	}
+	d.fifo = append(d.fifo, b.Samples()...)
+	d.block.Frames = b.Frames
+	n := copy(d.block.Data[:b.Frames * b.Channels], d.fifo)
+	d.fifo = d.fifo[:copy(d.fifo, d.fifo[n:])]
+	return d.block
+}
+
+func (d *delay) prepare(e *Engine) {
+	d.src.prepare(e)
+}
+
+func (d *delay) close() {
+	d.src.close()
+}
+
+func (d *delay) latency() float64 {
+	return d.src.latency() + d.seconds
+}
+
+// close lets go of the processors of a stage and everything upstream
+func (s *stage) close() {
+	if c, ok := s.proc.(Closer); ok {
+		c.Close()
+	}
+	for _, in := range s.inputs {
+		in.close()
+	}
+}
+
+// voice returns the voice playing an input stage, or nil
+func (s *stage) voice(e *Engine) *voice {
+	if s.track == nil {
+		return nil
+	}
+	return e.voices[s.track.key]
+}
+
+// dropVoices closes the voices the current graph doesn't use
+func (e *Engine) dropVoices() {
+	used := make(map[string]bool)
+	for _, t := range e.graph.tracks {
+		used[t.key] = true
+	}
+	for key, v := range e.voices {
+		if !used[key] {
+			v.Release()
+			delete(e.voices, key)
+		}
+	}
+}
+
+// inputGain returns how loud an input should be at a time in seconds
+func inputGain(node *Node, soloing bool, t float64) float64 {
+	if !node.audible(soloing) {
+		return 0
+	}
+	if p := node.Param("gain"); p != nil {
+		return dbToGain(p.At(t))
+	}
+	return 1
+}
+
+// time returns how far into the timeline block number index starts
+func (v *voice) time(index int) float64 {
+	return float64(v.start + uint64(index * BLOCK_SIZE)) / v.format.Rate
+}
+
+// pull returns block number index of the track, mixing the clips up
+// to it if needed. Blocks too old to remember come back as silence.
+func (v *voice) pull(index int, node *Node, soloing bool) *Block {
+	for v.read <= index {
+		from := int64(v.start) + int64(v.read * BLOCK_SIZE)
+		frames := v.end + v.tail - from
+		if frames < 0 {
+			frames = 0
+		} else if frames > BLOCK_SIZE {
+			frames = BLOCK_SIZE
+		}
+		b := v.blocks[v.read % VOICE_HISTORY]
+		b.Clear(int(frames))
+		for _, c := range v.clips {
+			c.mix(b, from)
+		}
+		data := b.Samples()
+		t := v.time(v.read)
+		env := node.Param("gain")
+		for i := 0; i < len(data); i += b.Channels {
+			var gain float32
+			if env != nil && env.Automated() {
+				// the ramp only fades mutes in and out, the envelope
+				// is followed exactly
+				target := 0.0
+				if node.audible(soloing) {
+					target = 1
+				}
+				at := dbToGain(env.At(t + float64(i / b.Channels) / v.format.Rate))
+				gain = float32(v.ramp.Next(target) * at)
+			} else {
+				gain = float32(v.ramp.Next(inputGain(node, soloing, t)))
+			}
+			for c := i; c < i + b.Channels; c++ {
+				data[c] *= gain
+			}
+		}
+		v.read++
+	}
+	if index < v.read - VOICE_HISTORY {
+		return v.silence
+	}
+	return v.blocks[index % VOICE_HISTORY]
+}
+
+// pull returns the next block out of the stage
+func (s *stage) pull(e *Engine, g *graph) *Block {
+	s.block.Clear(0)
+	if v := s.voice(e); v != nil {
+		b := v.pull(s.index, s.node, g.solo)
+		if s.mix != nil {
+			s.pan.apply(s.block, b, s.node.pan, s.node.law.Get(), v.time(s.index))
+		} else {
+			s.block.CopyFrom(b)
+		}
+		s.index++
+	}
+	mix := s.block
+	if s.node.name == "router" {
+		mix = s.mix
+		mix.Clear(0)
+	}
+	if s.duck != nil || s.auto != nil {
+		s.follow(g.time - s.late)
+	}
+	if s.duck != nil {
+		s.keys.Clear(0)
+	}
+	s.parts = s.parts[:0]
+	for i, in := range s.inputs {
+		if s.duck != nil && s.keyed[i] {
+			s.keys.Add(in.pull(e, g))
+		} else if s.auto != nil {
+			s.parts = append(s.parts, in.pull(e, g))
+		} else {
+			mix.Add(in.pull(e, g))
+		}
+	}
+	if s.duck != nil {
+		s.duck.Duck(mix, s.keys)
+	}
+	if s.auto != nil {
+		s.auto.Mix(mix, s.parts)
+	}
+	if s.node.name == "router" {
+		route(s.mode, s.block, mix)
+	}
+	if s.proc != nil && s.block.Frames > 0 {
+		s.process(g.time - s.late)
+	}
+	return s.block
+}
+
+// process runs the effect, crossfading to the dry signal when
+// bypassed. The effect keeps running while bypassed, so it picks up
+// where the signal is when it comes back, and the dry signal is held
+// back as long as the effect's, so they stay in step.
+func (s *stage) process(t float64) {
+	target := 1.0
+	if s.node.bypass.Get() {
+		target = 0
+	}
+	s.dry.CopyFrom(s.block)
+	s.dryLag.run(s.dry.Samples())
+	s.run(t)
+	if target == 1 && s.wet.gain == 1 {
+		return
+	}
+	data, dry := s.block.Samples(), s.dry.Samples()
+	for i := 0; i < len(data); i += s.block.Channels {
+		wet := float32(s.wet.Next(target))
+		for c := i; c < i + s.block.Channels; c++ {
+			data[c] = data[c] * wet + dry[c] * (1 - wet)
+		}
+	}
+}
+
+// lagLine delays samples by a fixed number, passing them through a
+// ring buffer
+type lagLine struct {
+	buf []float32
+	pos int
+}
+
+func newLagLine(samples int) lagLine {
+	return lagLine{buf: make([]float32, samples)}
+}
+
+func (l *lagLine) run(data []float32) {
+	if len(l.buf) == 0 {
+		return
+	}
+	for i := range data {
+		data[i], l.buf[l.pos] = l.buf[l.pos], data[i]
+		l.pos = (l.pos + 1) % len(l.buf)
+	}
+}
+
+// run processes the block starting at time t. An automated effect
+// is run in parts, its parameters following their envelopes frame by
+// frame; SoX effects only read their parameters when they start.
+func (s *stage) run(t float64) {
+	s.follow(t)
+	_, soxed := s.proc.(*soxProcessor)
+	if soxed || !s.node.automated() {
+		s.proc.Process(s.block)
+		return
+	}
+	ch, from := s.block.Channels, 0
+	for f := 1; f < s.block.Frames; f++ {
+		if s.ahead(t + float64(f) / s.block.Rate) {
+			s.part = Block{s.block.Format, s.block.Data[from * ch:], f - from}
+			s.proc.Process(&s.part)
+			for i, p := range s.params {
+				p.Set(s.next[i])
+			}
+			from = f
+		}
+	}
+	s.part = Block{s.block.Format, s.block.Data[from * ch:], s.block.Frames - from}
+	s.proc.Process(&s.part)
+}
+
+// follow sets the stage's copies of the parameters to their values
+// at time t
+func (s *stage) follow(t float64) {
+	for i, p := range s.node.params {
+		s.params[i].Set(p.At(t))
+	}
+}
+
+// ahead reads the parameters at time t into next, true when any of
+// them has moved
+func (s *stage) ahead(t float64) bool {
+	moved := false
+	for i, p := range s.node.params {
+		s.next[i] = math.Max(p.Min, math.Min(p.Max, p.At(t)))
+		if s.next[i] != s.params[i].Get() {
+			moved = true
+		}
+	}
+	return moved
+}
+
+// automated is true when any of the parameters of the node follow
+// an envelope. The pan is left to whatever pans the node.
+func (node *Node) automated() bool {
+	for _, p := range node.params {
+		if p.Automated() {
+			return true
+		}
+	}
+	return false
+}
+
+// time returns how far into the timeline the graph has been pulled,
+// in seconds
+func (e *Engine) time() float64 {
+	if e.format.Rate == 0 {
+		return 0
+	}
+	return float64(atomic.LoadUint64(&e.position)) / e.format.Rate
+}
+
+// playhead returns how far into the timeline what is coming out is,
+// behind time by what the effects hold back
+func (e *Engine) playhead() float64 {
+	if e.format.Rate == 0 {
+		return 0
+	}
+	return math.Max(0, e.time() - float64(atomic.LoadUint64(&e.lag)) / e.format.Rate)
+}
+
+func (e *Engine) Release() {
+	for _, g := range []*graph{e.old, e.graph} {
+		if g != nil {
+			g.final.close()
+		}
+	}
+	select {
+	case g := <-e.pending:
+		g.releaseVoices()
+	default:
+	}
+	for _, v := range e.voices {
+		v.Release()
+	}
+	if e.out != nil {
+		e.out.Release()
+	}
+}
+
+func (e *Engine) Stop() {
+	atomic.StoreInt32(&e.interrupt, 1)
+}
+
+func (e *Engine) Finished() bool {
+	return atomic.LoadInt32(&e.finished) != 0
+}
+
+// Flow plays blocks until every input has run dry or playback is
+// stopped, picking up new graphs between blocks. A graph arriving
+// during a crossfade waits for it to finish, and only the latest one
+// waiting is played.
+func (e *Engine) Flow() {
+	out := make([]int32, BLOCK_SIZE * e.format.Channels)
+	for atomic.LoadInt32(&e.interrupt) == 0 {
+		if e.old == nil {
+			select {
+			case g := <-e.pending:
+				e.adopt(g)
+			default:
+			}
+		}
+		e.graph.solo = soloing(e.graph.inputs)
+		e.graph.time = e.time()
+		mix := e.graph.final.pull(e, e.graph)
+		if e.old != nil {
+			e.old.solo = e.graph.solo
+			e.old.time = e.graph.time
+			prev := e.old.final.pull(e, e.old)
+			mix.Extend(prev.Frames)
+			prev.Extend(mix.Frames)
+			data, old := mix.Samples(), prev.Samples()
+			for i := 0; i < len(data); i += mix.Channels {
+				t := float32(e.fade.Next(1))
+				for c := i; c < i + mix.Channels; c++ {
+					data[c] = old[c] * (1 - t) + data[c] * t
+				}
+			}
+			if e.fade.gain == 1 {
+				e.old.final.close()
+				e.old = nil
+				e.dropVoices()
+			}
+		}
+		if mix.Frames == 0 {
+			break
+		}
+		atomic.AddUint64(&e.position, uint64(mix.Frames))
+		data := mix.Samples()
+		if e.skip > 0 {
+			n := e.skip
+			if n > mix.Frames {
+				n = mix.Frames
+			}
+			e.skip -= n
+			data = data[n * mix.Channels:]
+		}
+		dither(data, out)
+		e.out.Write(out, uint(len(data)))
+	}
+	atomic.StoreInt32(&e.finished, 1)
+}
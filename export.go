@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/krig/go-sox"
+)
+
+// Chapter is a named point on the timeline, exported as a chapter
+type Chapter struct {
+	Time float64 `json:"time"`
+	Title string `json:"title"`
+}
+
+// Export is how the episode is encoded and what it is tagged with,
+// saved with the project
+type Export struct {
+	File string `json:"file"`
+	// a bitrate like "128k", or for MP3 a VBR quality from "V0" to "V9"
+	Quality string `json:"quality"`
+	Title string `json:"title,omitempty"`
+	Episode string `json:"episode,omitempty"`
+	Artist string `json:"artist,omitempty"`
+	// an image file for the cover art
+	Cover string `json:"cover,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// the fields of the export dialog, in the order of Export
+var exportFields = []string{"file", "quality", "title", "episode", "artist", "cover", "description"}
+
+func defaultExport() Export {
+	return Export{File: "episode.mp3", Quality: "128k"}
+}
+
+func (ex *Export) values() []string {
+	return []string{ex.File, ex.Quality, ex.Title, ex.Episode, ex.Artist, ex.Cover, ex.Description}
+}
+
+func exportFrom(values []string) Export {
+	return Export{values[0], values[1], values[2], values[3], values[4], values[5], values[6]}
+}
+
+// kind returns what the file is encoded as, from its extension
+func (ex *Export) kind() string {
+	return strings.TrimPrefix(strings.ToLower(filepath.Ext(ex.File)), ".")
+}
+
+// compression returns the SoX compression for MP3: the bitrate in kbps,
+// or the VBR quality made negative
+func (ex *Export) compression() (float64, error) {
+	var v float64
+	q := strings.ToLower(ex.Quality)
+	if strings.HasPrefix(q, "v") {
+		if _, err := fmt.Sscan(q[1:], &v); err != nil || v < 0 || v > 9 {
+			return 0, fmt.Errorf("VBR quality goes from V0 to V9, not %s", ex.Quality)
+		}
+		// SoX reads -0 as 0, the default bitrate
+		return -math.Max(v, 0.01), nil
+	}
+	if _, err := fmt.Sscan(strings.TrimSuffix(q, "k"), &v); err != nil || v <= 0 {
+		return 0, fmt.Errorf("expected a bitrate like 128k, not %s", ex.Quality)
+	}
+	return v, nil
+}
+
+// sortedChapters returns the chapters in order, the first moved to
+// the start, as players expect
+func sortedChapters(chapters []Chapter) []Chapter {
+	out := append([]Chapter(nil), chapters...)
+	sort.Slice(out, func(i, j int) bool { return out[i].Time < out[j].Time })
+	if len(out) > 0 {
+		out[0].Time = 0
+	}
+	return out
+}
+
+// AddChapter puts a chapter at a time, keeping them in order
+func (canvas *CanvasPane) AddChapter(t float64, title string) {
+	canvas.chapters = append(canvas.chapters, Chapter{t, title})
+	sort.Slice(canvas.chapters, func(i, j int) bool { return canvas.chapters[i].Time < canvas.chapters[j].Time })
+}
+
+// cutChapters removes a stretch of time from a to b, moving the
+// chapters after it earlier. A chapter inside it moves to where the
+// cut was, so none are lost.
+func (canvas *CanvasPane) cutChapters(a, b float64) {
+	for i := range canvas.chapters {
+		c := &canvas.chapters[i]
+		if c.Time >= b {
+			c.Time -= b - a
+		} else if c.Time > a {
+			c.Time = a
+		}
+	}
+}
+
+// Export renders the project and encodes it in the background
+func (canvas *CanvasPane) Export(ex Export) {
+	if job := canvas.exportJob(ex); job != nil {
+		go job()
+	}
+}
+
+// exportJob returns what renders and encodes the project, or nil
+// when there is nothing to export
+func (canvas *CanvasPane) exportJob(ex Export) func() bool {
+	kind := ex.kind()
+	if kind != "mp3" && kind != "m4a" && kind != "opus" {
+		log.Println("Export to .mp3, .m4a or .opus, not", ex.File)
+		return nil
+	}
+	g := canvas.buildGraph()
+	if g == nil {
+		log.Println("Nothing to export.")
+		return nil
+	}
+	chapters := sortedChapters(canvas.chapters)
+	tmp := ex.File + ".part"
+	compression, err := ex.compression()
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	var e *Engine
+	if kind == "mp3" {
+		encoding := sox.NewEncodingInfo(sox.ENCODING_MP3, 0, compression, false)
+		defer encoding.Release()
+		e = NewEncode(g, tmp, "mp3", encoding)
+	} else {
+		if compression < 0 {
+			log.Println("VBR quality is only for MP3, give", kind, "a bitrate like 96k")
+			return nil
+		}
+		// SoX can't write these, so a WAV goes to ffmpeg
+		if _, err := exec.LookPath("ffmpeg"); err != nil {
+			log.Println("Exporting", kind, "needs ffmpeg")
+			return nil
+		}
+		e = NewEncode(g, tmp, "wav", nil)
+	}
+	if e == nil {
+		return nil
+	}
+	return func() bool {
+		e.Flow()
+		length := e.playhead()
+		e.Release()
+		defer os.Remove(tmp)
+		var err error
+		if kind == "mp3" {
+			err = tagMP3(tmp, ex, chapters, length)
+		} else {
+			err = encodeFFmpeg(tmp, ex, chapters, length, compression)
+		}
+		if err != nil {
+			log.Println("Export failed:", err)
+			return false
+		}
+		log.Println("Exported", ex.File)
+		return true
+	}
+}
+
+// tagMP3 writes the exported file: an ID3 tag, then the encoded audio
+func tagMP3(tmp string, ex Export, chapters []Chapter, length float64) error {
+	tag, err := id3Tag(ex, chapters, length)
+	if err != nil {
+		return err
+	}
+	audio, err := os.ReadFile(tmp)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(ex.File, append(tag, skipID3(audio)...), 0644)
+}
+
+// ffmetadata escapes a value for an ffmpeg metadata file
+func ffmetadata(s string) string {
+	r := strings.NewReplacer("\\", "\\\\", "=", "\\=", ";", "\\;", "#", "\\#", "\n", "\\\n")
+	return r.Replace(s)
+}
+
+// encodeFFmpeg encodes a WAV to AAC or Opus at a bitrate in kbps with
+// ffmpeg, the tags and chapters going in through a metadata file
+func encodeFFmpeg(wav string, ex Export, chapters []Chapter, length, kbps float64) error {
+	var meta strings.Builder
+	meta.WriteString(";FFMETADATA1\n")
+	for _, f := range []struct{ key, value string }{
+		{"title", ex.Title},
+		{"artist", ex.Artist},
+		{"track", ex.Episode},
+		{"comment", ex.Description},
+		{"description", ex.Description},
+	} {
+		if f.value != "" {
+			fmt.Fprintf(&meta, "%s=%s\n", f.key, ffmetadata(f.value))
+		}
+	}
+	for i, c := range chapters {
+		end := length
+		if i + 1 < len(chapters) {
+			end = chapters[i + 1].Time
+		}
+		fmt.Fprintf(&meta, "[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n", int64(c.Time * 1000), int64(end * 1000), ffmetadata(c.Title))
+	}
+	metafile := wav + ".txt"
+	if err := os.WriteFile(metafile, []byte(meta.String()), 0644); err != nil {
+		return err
+	}
+	defer os.Remove(metafile)
+
+	bitrate := fmt.Sprintf("%gk", kbps)
+	args := []string{"-y", "-loglevel", "error", "-i", wav, "-i", metafile}
+	maps := []string{"-map", "0:a", "-map_metadata", "1", "-map_chapters", "1"}
+	if ex.kind() == "m4a" {
+		if ex.Cover != "" {
+			args = append(args, "-i", ex.Cover)
+			maps = append(maps, "-map", "2:v", "-c:v", "copy", "-disposition:v", "attached_pic")
+		}
+		maps = append(maps, "-c:a", "aac", "-b:a", bitrate)
+	} else {
+		if ex.Cover != "" {
+			log.Println("Opus files get no cover art")
+		}
+		maps = append(maps, "-c:a", "libopus", "-b:a", bitrate)
+	}
+	args = append(append(args, maps...), ex.File)
+	out, err := exec.Command("ffmpeg", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ffmpeg: %v %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// exportHeadless exports the loaded project to a file, as set up in
+// the project but for the file name and the quality if given, and
+// waits for it to finish
+func exportHeadless(canvas *CanvasPane, file, quality string) bool {
+	ex := canvas.export
+	ex.File = file
+	if quality != "" {
+		ex.Quality = quality
+	}
+	job := canvas.exportJob(ex)
+	return job != nil && job()
+}
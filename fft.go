@@ -0,0 +1,51 @@
+package main
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// fftSize returns the smallest power of two of at least n
+func fftSize(n int) int {
+	size := 1
+	for size < n {
+		size <<= 1
+	}
+	return size
+}
+
+// fft transforms x in place, its length a power of two. The inverse
+// is scaled by 1/n, so fft and back gives x again.
+func fft(x []complex128, inverse bool) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j & bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j |= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	sign := -1.0
+	if inverse {
+		sign = 1
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Rect(1, sign * 2 * math.Pi / float64(size))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size / 2; k++ {
+				a, b := x[start + k], x[start + k + size / 2] * w
+				x[start + k], x[start + k + size / 2] = a + b, a - b
+				w *= step
+			}
+		}
+	}
+	if inverse {
+		for i := range x {
+			x[i] /= complex(float64(n), 0)
+		}
+	}
+}
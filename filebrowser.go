@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/krig/Go-SDL2/sdl"
+)
+
+const (
+	ROW_HEIGHT = int32(16)
+	PLACES_WIDTH = int32(120)
+	MAX_RECENT = 8
+)
+
+// FileFilter limits the file browser to files with the given extensions
+type FileFilter struct {
+	Name string
+	Exts []string
+}
+
+var audioFilters = []FileFilter{
+	{"audio", []string{".wav", ".mp3", ".flac", ".ogg"}},
+	{"wav", []string{".wav"}},
+	{"mp3", []string{".mp3"}},
+	{"flac", []string{".flac"}},
+	{"ogg", []string{".ogg"}},
+}
+
+func (filter *FileFilter) Match(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range filter.Exts {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+type fileEntry struct {
+	path string
+	dir bool
+	label *Label
+}
+
+// FileBrowser is a dialog for picking a file to open. On the left are
+// the home directory and recently used locations, on the right is the
+// current directory, with a row of extension filters below.
+type FileBrowser struct {
+	Dialog
+	dir string
+	path Label
+	entries []fileEntry
+	places []fileEntry
+	filters []*TextButton
+	filter int
+	selected int
+	scroll int
+	lastclick uint32
+	recent []string
+	callback func(filename string)
+}
+
+func recentFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "podcast-studio", "recent")
+}
+
+func (fb *FileBrowser) Init(rsc *Resources) {
+	fb.Dialog.Init(rsc, "Open File", 520, 380)
+	fb.path.Init(rsc.renderer, fb.Pos, " ", rsc.TitleFont, rsc.TitleColor)
+	fb.AddButton("Cancel", func() {
+		fb.Hide()
+	})
+	fb.AddButton("Open", func() {
+		fb.activate(fb.selected)
+	})
+	fb.OnAccept(func() {
+		fb.activate(fb.selected)
+	})
+	for i, f := range audioFilters {
+		idx := i
+		b := &TextButton{}
+		b.Init(rsc, sdl.Rect{0, 0, 40, DIALOG_BUTTON_HEIGHT}, f.Name)
+		b.OnClick(func() {
+			fb.filter = idx
+			fb.chdir(fb.dir)
+		})
+		fb.filters = append(fb.filters, b)
+	}
+	fb.loadRecent()
+}
+
+// Open shows the browser, calling callback with the chosen file
+func (fb *FileBrowser) Open(callback func(filename string)) {
+	fb.callback = callback
+	fb.updatePlaces()
+	if fb.dir == "" {
+		if len(fb.recent) > 0 {
+			fb.dir = fb.recent[0]
+		} else if wd, err := os.Getwd(); err == nil {
+			fb.dir = wd
+		}
+	}
+	fb.chdir(fb.dir)
+	fb.Show()
+}
+
+func (fb *FileBrowser) loadRecent() {
+	file, err := os.Open(recentFile())
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() && len(fb.recent) < MAX_RECENT {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			fb.recent = append(fb.recent, line)
+		}
+	}
+}
+
+func (fb *FileBrowser) saveRecent() {
+	name := recentFile()
+	if name == "" {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		log.Println(err)
+		return
+	}
+	data := strings.Join(fb.recent, "\n") + "\n"
+	if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+		log.Println(err)
+	}
+}
+
+// addRecent moves dir to the top of the recent locations
+func (fb *FileBrowser) addRecent(dir string) {
+	recent := []string{dir}
+	for _, r := range fb.recent {
+		if r != dir && len(recent) < MAX_RECENT {
+			recent = append(recent, r)
+		}
+	}
+	fb.recent = recent
+	fb.saveRecent()
+}
+
+func (fb *FileBrowser) newEntry(path, text string, dir bool) fileEntry {
+	label := &Label{}
+	label.Init(fb.rsc.renderer, sdl.Rect{}, text, fb.rsc.TitleFont, fb.rsc.TitleColor)
+	return fileEntry{path, dir, label}
+}
+
+func destroyEntries(entries []fileEntry) {
+	for _, e := range entries {
+		e.label.Destroy()
+	}
+}
+
+func (fb *FileBrowser) updatePlaces() {
+	destroyEntries(fb.places)
+	fb.places = nil
+	if home, err := os.UserHomeDir(); err == nil {
+		fb.places = append(fb.places, fb.newEntry(home, "Home", true))
+	}
+	for _, r := range fb.recent {
+		fb.places = append(fb.places, fb.newEntry(r, filepath.Base(r), true))
+	}
+}
+
+func (fb *FileBrowser) chdir(dir string) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	fb.dir = filepath.Clean(dir)
+	fb.path.Text = fb.dir
+	fb.path.Update(fb.rsc.renderer)
+
+	destroyEntries(fb.entries)
+	fb.entries = nil
+	if parent := filepath.Dir(fb.dir); parent != fb.dir {
+		fb.entries = append(fb.entries, fb.newEntry(parent, "..", true))
+	}
+	var dirs, matches []string
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		if f.IsDir() {
+			dirs = append(dirs, f.Name())
+		} else if audioFilters[fb.filter].Match(f.Name()) {
+			matches = append(matches, f.Name())
+		}
+	}
+	sort.Strings(dirs)
+	sort.Strings(matches)
+	for _, d := range dirs {
+		fb.entries = append(fb.entries, fb.newEntry(filepath.Join(fb.dir, d), d + "/", true))
+	}
+	for _, m := range matches {
+		fb.entries = append(fb.entries, fb.newEntry(filepath.Join(fb.dir, m), m, false))
+	}
+	fb.selected = -1
+	fb.scroll = 0
+}
+
+// activate enters a directory or picks a file
+func (fb *FileBrowser) activate(i int) {
+	if i < 0 || i >= len(fb.entries) {
+		return
+	}
+	e := fb.entries[i]
+	if e.dir {
+		fb.chdir(e.path)
+		return
+	}
+	fb.addRecent(fb.dir)
+	fb.Hide()
+	if fb.callback != nil {
+		fb.callback(e.path)
+	}
+}
+
+func (fb *FileBrowser) listRect() sdl.Rect {
+	body := fb.Body()
+	return sdl.Rect{body.X + PLACES_WIDTH, body.Y + ROW_HEIGHT + 4, body.W - PLACES_WIDTH, body.H - ROW_HEIGHT - 4}
+}
+
+func (fb *FileBrowser) placesRect() sdl.Rect {
+	body := fb.Body()
+	return sdl.Rect{body.X, body.Y + ROW_HEIGHT + 4, PLACES_WIDTH - 4, body.H - ROW_HEIGHT - 4}
+}
+
+func (fb *FileBrowser) visibleRows() int {
+	return int(fb.listRect().H / ROW_HEIGHT)
+}
+
+// rowAt returns the entry index at the given point, or -1
+func rowAt(rect sdl.Rect, first, count int, x, y int32) int {
+	if !rect.Contains(x, y) {
+		return -1
+	}
+	i := first + int((y - rect.Y) / ROW_HEIGHT)
+	if i >= count {
+		return -1
+	}
+	return i
+}
+
+func (fb *FileBrowser) scrollTo(i int) {
+	rows := fb.visibleRows()
+	if i < fb.scroll {
+		fb.scroll = i
+	} else if i >= fb.scroll + rows {
+		fb.scroll = i - rows + 1
+	}
+	if fb.scroll > len(fb.entries) - rows {
+		fb.scroll = len(fb.entries) - rows
+	}
+	if fb.scroll < 0 {
+		fb.scroll = 0
+	}
+}
+
+func (fb *FileBrowser) UpdateLayout(space sdl.Rect) {
+	fb.Dialog.UpdateLayout(space)
+	x := fb.Pos.X + DIALOG_INSET
+	y := fb.Pos.Y + fb.Pos.H - DIALOG_INSET - DIALOG_BUTTON_HEIGHT
+	for _, b := range fb.filters {
+		b.Pos.X = x
+		b.Pos.Y = y
+		x += b.Pos.W + 2
+	}
+}
+
+func drawRows(rend *sdl.Renderer, rect sdl.Rect, entries []fileEntry, first, selected int, highlight sdl.Color) {
+	y := rect.Y
+	for i := first; i < len(entries) && y + ROW_HEIGHT <= rect.Y + rect.H; i++ {
+		row := sdl.Rect{rect.X, y, rect.W, ROW_HEIGHT}
+		if i == selected {
+			rend.SetDrawColor(highlight)
+			rend.FillRect(&row)
+		}
+		l := entries[i].label
+		l.Pos = sdl.Rect{rect.X + 4, y, l.texwidth, ROW_HEIGHT}
+		if l.Pos.W > rect.W - 4 {
+			l.Pos.W = rect.W - 4
+		}
+		l.Draw(rend)
+		y += ROW_HEIGHT
+	}
+}
+
+func (fb *FileBrowser) Draw(rend *sdl.Renderer) {
+	fb.Dialog.Draw(rend)
+	body := fb.Body()
+	fb.path.Pos = sdl.Rect{body.X, body.Y, fb.path.texwidth, ROW_HEIGHT}
+	fb.path.Draw(rend)
+
+	places := fb.placesRect()
+	rend.SetDrawColor(fb.rsc.TitleBarColor)
+	rend.FillRect(&places)
+	drawRows(rend, places, fb.places, 0, -1, fb.rsc.TitleBarColor)
+
+	list := fb.listRect()
+	rend.SetDrawColor(lighten(fb.rsc.BackgroundColor, 17))
+	rend.FillRect(&list)
+	rend.SetDrawColor(darken(fb.rsc.TitleBarColor, 20))
+	rend.DrawRect(&list)
+	drawRows(rend, list, fb.entries, fb.scroll, fb.selected, hexcolor(0x9fd5f0))
+
+	for i, b := range fb.filters {
+		if i == fb.filter {
+			b.Color = hexcolor(0x9fd5f0)
+		} else {
+			b.Color = darken(fb.rsc.TitleBarColor, 20)
+		}
+		b.Draw(rend)
+	}
+}
+
+func (fb *FileBrowser) Destroy() {
+	fb.Dialog.Destroy()
+	fb.path.Destroy()
+	destroyEntries(fb.entries)
+	destroyEntries(fb.places)
+	for _, b := range fb.filters {
+		b.Destroy()
+	}
+}
+
+func (fb *FileBrowser) OnMouseMotionEvent(event *sdl.MouseMotionEvent) bool {
+	for _, b := range fb.filters {
+		b.OnMouseMotionEvent(event)
+	}
+	return fb.Dialog.OnMouseMotionEvent(event)
+}
+
+func (fb *FileBrowser) OnMouseButtonEvent(event *sdl.MouseButtonEvent) bool {
+	for _, b := range fb.filters {
+		if !b.OnMouseButtonEvent(event) {
+			return false
+		}
+	}
+	if event.Button == sdl.BUTTON_LEFT && event.State == sdl.PRESSED {
+		if i := rowAt(fb.placesRect(), 0, len(fb.places), event.X, event.Y); i >= 0 {
+			fb.chdir(fb.places[i].path)
+			return false
+		}
+		if i := rowAt(fb.listRect(), fb.scroll, len(fb.entries), event.X, event.Y); i >= 0 {
+			now := sdl.GetTicks()
+			if i == fb.selected && now - fb.lastclick < DOUBLECLICK_MS {
+				fb.activate(i)
+			} else {
+				fb.selected = i
+			}
+			fb.lastclick = now
+			return false
+		}
+	}
+	return fb.Dialog.OnMouseButtonEvent(event)
+}
+
+func (fb *FileBrowser) OnMouseWheelEvent(event *sdl.MouseWheelEvent) bool {
+	fb.scroll -= int(event.Y) * 3
+	fb.scrollTo(fb.scroll)
+	return false
+}
+
+func (fb *FileBrowser) OnKeyboardEvent(event *sdl.KeyboardEvent) bool {
+	if event.State == sdl.PRESSED {
+		switch event.Keysym.Keycode {
+		case sdl.K_UP:
+			if fb.selected > 0 {
+				fb.selected--
+			}
+			fb.scrollTo(fb.selected)
+			return false
+		case sdl.K_DOWN:
+			if fb.selected < len(fb.entries) - 1 {
+				fb.selected++
+			}
+			fb.scrollTo(fb.selected)
+			return false
+		case sdl.K_BACKSPACE:
+			fb.chdir(filepath.Dir(fb.dir))
+			return false
+		}
+	}
+	return fb.Dialog.OnKeyboardEvent(event)
+}
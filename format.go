@@ -0,0 +1,381 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+
+	"github.com/krig/Go-SDL2/sdl"
+)
+
+// the precision the sound card is fed, dithered down to from float
+const OUTPUT_BITS = 16
+
+const (
+	// zero crossings of the resampling filter on either side, and the
+	// steps it is tabulated at between them
+	SINC_ZEROS = 32
+	SINC_STEPS = 256
+	// where the filter cuts off, as a part of the lower Nyquist, so
+	// its slope is over before anything can fold back
+	SINC_CUTOFF = 0.95
+)
+
+func (f Format) String() string {
+	layout := fmt.Sprintf("%dch", f.Channels)
+	switch f.Channels {
+	case 1:
+		layout = "mono"
+	case 2:
+		layout = "stereo"
+	}
+	return fmt.Sprintf("%gk %s", f.Rate / 1000, layout)
+}
+
+// accepts returns the format a node wants everything linked to it
+// converted to. Mixing takes the highest rate and the most channels
+// arriving, at least stereo, anything else takes what it gets.
+func (node *Node) accepts(in []Format) Format {
+	var f Format
+	if node.mixes() && len(in) > 0 {
+		f.Channels = 2
+	}
+	for _, i := range in {
+		if i.Rate > f.Rate {
+			f.Rate = i.Rate
+		}
+		if i.Channels > f.Channels {
+			f.Channels = i.Channels
+		}
+	}
+	return f
+}
+
+// produces returns the format coming out of a node, given what it accepts
+func (node *Node) produces(in Format) Format {
+	switch node.name {
+	case "input":
+		// mono and stereo files are panned into stereo
+		if canPan(node.format) {
+			return Format{node.format.Rate, 2}
+		}
+		return node.format
+	case "router":
+		return routedFormat(node.routerMode(), in)
+	}
+	return in
+}
+
+// linkFormats works out the format on the link out of every node
+func (canvas *CanvasPane) linkFormats() map[*Node]Format {
+	prev := make(map[*Node][]*Node)
+	for _, n := range canvas.nodes {
+		if n.next != nil {
+			prev[n.next] = append(prev[n.next], n)
+		}
+	}
+	formats := make(map[*Node]Format)
+	var format func(n *Node) Format
+	format = func(n *Node) Format {
+		if f, ok := formats[n]; ok {
+			return f
+		}
+		// guards against loops
+		formats[n] = Format{}
+		var in []Format
+		for _, p := range prev[n] {
+			if f := format(p); f.Rate > 0 {
+				in = append(in, f)
+			}
+		}
+		f := n.produces(n.accepts(in))
+		formats[n] = f
+		return f
+	}
+	for _, n := range canvas.nodes {
+		format(n)
+	}
+	return formats
+}
+
+// UpdateFormats renegotiates the formats when the links change
+func (canvas *CanvasPane) UpdateFormats() {
+	key := canvas.topologyKey()
+	if key == canvas.formatKey {
+		return
+	}
+	canvas.formatKey = key
+	canvas.formats = canvas.linkFormats()
+}
+
+// DrawFormats labels every link with the format flowing through it
+func (canvas *CanvasPane) DrawFormats(rend *sdl.Renderer) {
+	for _, n := range canvas.nodes {
+		f := canvas.formats[n]
+		if n.next == nil || f.Rate == 0 {
+			continue
+		}
+		text := f.String()
+		label := canvas.formatLabels[text]
+		if label == nil {
+			label = &Label{}
+			label.Init(rend, sdl.Rect{}, text, canvas.rsc.TitleFont, hexcolor(0xa0a0a0))
+			canvas.formatLabels[text] = label
+		}
+		path := canvas.linkPath(n)
+		a, b := path[len(path) / 2 - 1], path[len(path) / 2]
+		x, y := canvas.cam.ToScreen(float64(a.X + b.X) / 2, float64(a.Y + b.Y) / 2)
+		w := int32(float64(label.texwidth) * canvas.cam.Zoom * 0.75)
+		h := int32(float64(label.texheight) * canvas.cam.Zoom * 0.75)
+		label.Pos = sdl.Rect{x - w / 2, y - h - 2, w, h}
+		label.DrawScaled(rend, canvas.cam.Zoom * 0.75)
+	}
+}
+
+// negotiate sets the format of a stage and everything upstream. It
+// pans everything but inputs going into a mixer, and puts a converter
+// in front of every input arriving in another format. A stage is a
+// key for a ducker when its node is marked as one or everything
+// linked to it is, so keys can go through effects first.
+func (s *stage) negotiate(formats map[*Node]Format) {
+	s.format = formats[s.node]
+	s.mode = s.node.routerMode()
+	s.key = s.node.key.Get()
+	var in []Format
+	for _, p := range s.inputs {
+		st := p.(*stage)
+		st.negotiate(formats)
+		if st.format.Rate > 0 {
+			in = append(in, st.format)
+		}
+	}
+	if !s.key && s.track == nil && len(s.inputs) > 0 {
+		s.key = true
+		for _, p := range s.inputs {
+			s.key = s.key && p.(*stage).key
+		}
+	}
+	s.accept = s.node.accepts(in)
+	for i, p := range s.inputs {
+		st := p.(*stage)
+		f := st.format
+		if s.node.mixes() && st.node.name != "input" && canPan(f) {
+			p = newPanner(p, st.node, s.node, f)
+			f = Format{f.Rate, 2}
+		}
+		if f != s.accept && f.Rate > 0 {
+			p = newConverter(p, f, s.accept)
+		}
+		s.inputs[i] = p
+		s.keyed = append(s.keyed, st.key)
+	}
+}
+
+// converter resamples and remaps the channels of whatever is pulled
+// through it
+type converter struct {
+	src puller
+	from, to Format
+	block *Block
+	// frames from upstream, already in the new channel layout
+	fifo []float32
+	pos float64
+	step float64
+	// nil when the rate stays the same
+	filter *sincFilter
+	ended bool
+}
+
+func newConverter(src puller, from, to Format) *converter {
+	log.Println("Converting", from, "to", to)
+	c := &converter{
+		src: src,
+		from: from,
+		to: to,
+		block: NewBlock(to),
+		step: from.Rate / to.Rate,
+	}
+	if from.Rate != to.Rate {
+		c.filter = newSincFilter(c.step)
+	}
+	return c
+}
+
+// at returns a sample from the fifo, silence outside of it
+func (c *converter) at(frame, channel int) float32 {
+	i := frame * c.to.Channels + channel
+	if frame < 0 || i >= len(c.fifo) {
+		return 0
+	}
+	return c.fifo[i]
+}
+
+func (c *converter) pull(e *Engine, g *graph) *Block {
+	ch := c.to.Channels
+	out := c.block
+	out.Frames = 0
+	// frames needed either side of the read position
+	width := 0
+	if c.filter != nil {
+		width = c.filter.width
+	}
+	for out.Frames < BLOCK_SIZE {
+		i := int(c.pos)
+		if !c.ended && (i + width + 1) * ch > len(c.fifo) {
+			b := c.src.pull(e, g)
+			if b.Frames == 0 {
+				c.ended = true
+			}
+			c.fifo = append(c.fifo, mapChannels(b, ch)...)
+			continue
+		}
+		if c.ended && i * ch >= len(c.fifo) {
+			break
+		}
+		dst := out.Data[out.Frames * ch:(out.Frames + 1) * ch]
+		if c.filter == nil {
+			for k := range dst {
+				dst[k] = c.at(i, k)
+			}
+		} else {
+			taps := c.filter.weights(c.pos - float64(i))
+			first := i - width + 1
+			for k := range dst {
+				var sum float32
+				for n, w := range taps {
+					sum += w * c.at(first + n, k)
+				}
+				dst[k] = sum
+			}
+		}
+		out.Frames++
+		c.pos += c.step
+	}
+	// keep the frames before the read position the filter reaches
+	keep := 0
+	if width > 0 {
+		keep = width - 1
+	}
+	if drop := int(c.pos) - keep; drop > 0 {
+		c.fifo = c.fifo[drop * ch:]
+		c.pos -= float64(drop)
+	}
+	return out
+}
+
+// sincTable is one side of a Blackman windowed sinc, from the middle
+// out to SINC_ZEROS zero crossings
+var sincTable = makeSincTable()
+
+func makeSincTable() []float64 {
+	table := make([]float64, SINC_ZEROS * SINC_STEPS + 1)
+	for i := range table {
+		x := float64(i) / SINC_STEPS
+		sinc := 1.0
+		if i > 0 {
+			sinc = math.Sin(math.Pi * x) / (math.Pi * x)
+		}
+		window := 0.42 + 0.5 * math.Cos(math.Pi * x / SINC_ZEROS) + 0.08 * math.Cos(2 * math.Pi * x / SINC_ZEROS)
+		table[i] = sinc * window
+	}
+	return table
+}
+
+// sincFilter interpolates a signal read step frames at a time,
+// filtering out what the slower of the two rates can't hold, so
+// nothing folds back when going down in rate
+type sincFilter struct {
+	cutoff float64
+	// how many frames the filter reaches on either side
+	width int
+	taps []float32
+}
+
+func newSincFilter(step float64) *sincFilter {
+	cutoff := SINC_CUTOFF
+	if step > 1 {
+		cutoff /= step
+	}
+	width := int(math.Ceil(SINC_ZEROS / cutoff))
+	return &sincFilter{cutoff: cutoff, width: width, taps: make([]float32, 2 * width)}
+}
+
+// weights returns the taps for reading t past a frame, for the frames
+// from width - 1 before it to width after
+func (f *sincFilter) weights(t float64) []float32 {
+	for k := range f.taps {
+		x := math.Abs(float64(k - f.width + 1) - t) * f.cutoff * SINC_STEPS
+		j := int(x)
+		v := 0.0
+		if j + 1 < len(sincTable) {
+			v = sincTable[j] + (sincTable[j + 1] - sincTable[j]) * (x - float64(j))
+		}
+		f.taps[k] = float32(v * f.cutoff)
+	}
+	return f.taps
+}
+
+func (c *converter) prepare(e *Engine) {
+	c.src.prepare(e)
+}
+
+func (c *converter) latency() float64 {
+	return c.src.latency()
+}
+
+func (c *converter) close() {
+	c.src.close()
+}
+
+// hermite interpolates between y1 and y2 with a cubic through all four
+// samples, t from 0 at y1 to 1 at y2
+func hermite(y0, y1, y2, y3, t float32) float32 {
+	a := -y0 / 2 + 3 * y1 / 2 - 3 * y2 / 2 + y3 / 2
+	b := y0 - 5 * y1 / 2 + 2 * y2 - y3 / 2
+	d := -y0 / 2 + y2 / 2
+	return ((a * t + b) * t + d) * t + y1
+}
+
+// mapChannels converts a block to another number of channels.
+// Extra channels are folded down by averaging, missing ones are
+// copied round from the ones there are.
+func mapChannels(b *Block, channels int) []float32 {
+	in := b.Samples()
+	if b.Channels == channels {
+		return in
+	}
+	out := make([]float32, b.Frames * channels)
+	for f := 0; f < b.Frames; f++ {
+		src, dst := in[f * b.Channels:(f + 1) * b.Channels], out[f * channels:(f + 1) * channels]
+		if channels > b.Channels {
+			for k := range dst {
+				dst[k] = src[k % b.Channels]
+			}
+			continue
+		}
+		for k, s := range src {
+			dst[k % channels] += s
+		}
+		for k := range dst {
+			dst[k] /= float32((b.Channels - k + channels - 1) / channels)
+		}
+	}
+	return out
+}
+
+// dither quantizes samples to OUTPUT_BITS with triangular noise, so
+// quiet passages fade into hiss instead of distortion, and scales
+// them up to fill 32 bits for SoX
+func dither(in []float32, out []int32) {
+	steps := float64(int32(1) << (OUTPUT_BITS - 1))
+	for i, s := range in {
+		v := float64(s) * steps + rand.Float64() - rand.Float64()
+		if v > steps - 1 {
+			v = steps - 1
+		} else if v < -steps {
+			v = -steps
+		}
+		out[i] = int32(math.Floor(v + 0.5)) << (32 - OUTPUT_BITS)
+	}
+}
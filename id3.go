@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+)
+
+// id3Text encodes a string as UTF-16 with a byte order mark, the
+// encoding every ID3v2.3 reader understands
+func id3Text(s string) []byte {
+	var b bytes.Buffer
+	b.Write([]byte{0xff, 0xfe})
+	for _, u := range utf16.Encode([]rune(s)) {
+		binary.Write(&b, binary.LittleEndian, u)
+	}
+	return b.Bytes()
+}
+
+// id3Frame wraps the body of a frame with its header
+func id3Frame(id string, body []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString(id)
+	binary.Write(&b, binary.BigEndian, uint32(len(body)))
+	b.Write([]byte{0, 0})
+	b.Write(body)
+	return b.Bytes()
+}
+
+// id3TextFrame is a frame holding one string, like the title
+func id3TextFrame(id, text string) []byte {
+	return id3Frame(id, append([]byte{1}, id3Text(text)...))
+}
+
+func id3Comment(text string) []byte {
+	body := append([]byte{1}, "eng"...)
+	body = append(body, id3Text("")...)
+	body = append(body, 0, 0)
+	return id3Frame("COMM", append(body, id3Text(text)...))
+}
+
+// id3Picture is the cover art frame, read from an image file
+func id3Picture(filename string) ([]byte, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	mime := "image/jpeg"
+	if strings.ToLower(filepath.Ext(filename)) == ".png" {
+		mime = "image/png"
+	}
+	body := []byte{0}
+	body = append(body, mime...)
+	// a front cover, with no description
+	body = append(body, 0, 3, 0)
+	return id3Frame("APIC", append(body, data...)), nil
+}
+
+// id3Chapter is a CHAP frame, times in milliseconds, with the title
+// of the chapter inside it
+func id3Chapter(id string, start, end uint32, title string) []byte {
+	var b bytes.Buffer
+	b.WriteString(id)
+	b.WriteByte(0)
+	binary.Write(&b, binary.BigEndian, []uint32{start, end, 0xffffffff, 0xffffffff})
+	b.Write(id3TextFrame("TIT2", title))
+	return id3Frame("CHAP", b.Bytes())
+}
+
+// id3Contents is the CTOC frame listing the chapters in order
+func id3Contents(ids []string) []byte {
+	var b bytes.Buffer
+	b.WriteString("toc")
+	// top level and ordered
+	b.Write([]byte{0, 3, byte(len(ids))})
+	for _, id := range ids {
+		b.WriteString(id)
+		b.WriteByte(0)
+	}
+	return id3Frame("CTOC", b.Bytes())
+}
+
+// id3Tag builds an ID3v2.3 tag with the metadata of an export and its
+// chapters, for a file lasting length seconds
+func id3Tag(ex Export, chapters []Chapter, length float64) ([]byte, error) {
+	var frames bytes.Buffer
+	for _, f := range []struct{ id, text string }{
+		{"TIT2", ex.Title},
+		{"TPE1", ex.Artist},
+		{"TRCK", ex.Episode},
+	} {
+		if f.text != "" {
+			frames.Write(id3TextFrame(f.id, f.text))
+		}
+	}
+	if ex.Description != "" {
+		frames.Write(id3Comment(ex.Description))
+	}
+	if ex.Cover != "" {
+		pic, err := id3Picture(ex.Cover)
+		if err != nil {
+			return nil, err
+		}
+		frames.Write(pic)
+	}
+	if len(chapters) > 255 {
+		return nil, fmt.Errorf("%d chapters, at most 255 fit in a tag", len(chapters))
+	}
+	var ids []string
+	for i, c := range chapters {
+		end := length
+		if i + 1 < len(chapters) {
+			end = chapters[i + 1].Time
+		}
+		id := fmt.Sprintf("chp%d", i)
+		ids = append(ids, id)
+		frames.Write(id3Chapter(id, uint32(c.Time * 1000), uint32(end * 1000), c.Title))
+	}
+	if len(ids) > 0 {
+		frames.Write(id3Contents(ids))
+	}
+	size := frames.Len()
+	header := []byte{'I', 'D', '3', 3, 0, 0,
+		byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}
+	return append(header, frames.Bytes()...), nil
+}
+
+// skipID3 returns the audio after any ID3v2 tag at the start of a file
+func skipID3(data []byte) []byte {
+	if len(data) < 10 || string(data[:3]) != "ID3" {
+		return data
+	}
+	size := int(data[6]) << 21 | int(data[7]) << 14 | int(data[8]) << 7 | int(data[9])
+	if data[5] & 0x10 != 0 {
+		// a footer
+		size += 10
+	}
+	if 10 + size > len(data) {
+		return data
+	}
+	return data[10 + size:]
+}
@@ -3,39 +3,28 @@ package main
 import (
 	"flag"
 	"log"
+	"os"
 	"runtime"
 
 	"github.com/krig/Go-SDL2/sdl"
 	"github.com/krig/Go-SDL2/ttf"
 	"github.com/krig/go-sox"
-	"github.com/mattn/go-gtk/gtk"
-	"github.com/mattn/go-gtk/glib"
 )
 
-func openFileDialog(callback func(filename string)) {
-	filechooserdialog := gtk.NewFileChooserDialog(
-		"Choose File",
-		nil,
-		gtk.FILE_CHOOSER_ACTION_OPEN,
-		gtk.STOCK_OK,
-		gtk.RESPONSE_ACCEPT)
-	filter := gtk.NewFileFilter()
-	filter.AddPattern("*.wav")
-	filter.AddPattern("*.mp3")
-	filechooserdialog.AddFilter(filter)
-	filechooserdialog.Response(func() {
-		callback(filechooserdialog.GetFilename())
-		filechooserdialog.Destroy()
-	})
-
-	filechooserdialog.Show()
-}
-
 func main() {
 	runtime.LockOSThread()
 
 	// Parse command line
+	export := flag.String("export", "", "export the project to a file and quit, without a window")
+	quality := flag.String("quality", "", "bitrate or MP3 VBR quality to export at, instead of the project's")
 	flag.Parse()
+	// exits with an error once everything is cleaned up
+	failed := false
+	defer func() {
+		if failed {
+			os.Exit(1)
+		}
+	}()
 	tracks := []string{}
 	if flag.NArg() > 0 {
 		tracks = flag.Args()[0:]
@@ -60,20 +49,24 @@ func main() {
 		log.Printf("%s: %s (%x)\n", e.Name(), e.Usage(), e.Flags())
 	}
 
+	flags := uint32(sdl.WINDOW_SHOWN | sdl.WINDOW_OPENGL | sdl.RENDERER_ACCELERATED | sdl.RENDERER_PRESENTVSYNC)
+	if *export != "" {
+		// the canvas still wants a renderer for its labels
+		os.Setenv("SDL_VIDEODRIVER", "dummy")
+		flags = sdl.WINDOW_HIDDEN | sdl.RENDERER_SOFTWARE
+	}
+
 	if sdl.Init(sdl.INIT_NOPARACHUTE|sdl.INIT_VIDEO|sdl.INIT_EVENTS) != 0 {
 		log.Fatal(sdl.GetError())
 	}
 	defer sdl.Quit()
 
-	gtk.Init(nil)
-
 	//sdl.GL_SetAttribute(sdl.GL_CONTEXT_MAJOR_VERSION, 2)
 	//sdl.GL_SetAttribute(sdl.GL_CONTEXT_MINOR_VERSION, 1)
 	//sdl.GL_SetAttribute(sdl.GL_DOUBLEBUFFER, 1)
 	//sdl.GL_SetAttribute(sdl.GL_DEPTH_SIZE, 24)
 
-	window, renderer := sdl.CreateWindowAndRenderer(640, 480,
-		sdl.WINDOW_SHOWN | sdl.WINDOW_OPENGL | sdl.RENDERER_ACCELERATED | sdl.RENDERER_PRESENTVSYNC)
+	window, renderer := sdl.CreateWindowAndRenderer(640, 480, flags)
 	if (window == nil) || (renderer == nil) {
 		log.Fatal(sdl.GetError())
 	}
@@ -95,16 +88,11 @@ func main() {
 	defer screen.rsc.Free()
 	defer screen.Destroy()
 
-	loop := glib.NewMainLoop(nil, false)
-
-	glib.IdleAdd(func() bool {
-		ret := studioUpdate(window, renderer, screen)
-		if !ret {
-			loop.Quit()
-			return false
-		}
-		return true
-	})
+	if *export != "" {
+		failed = !exportHeadless(screen.Canvas, *export, *quality)
+		return
+	}
 
-	loop.Run()
+	for studioUpdate(window, renderer, screen) {
+	}
 }
\ No newline at end of file
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var (
+	// pan of the link out of a node, used by inputs and by
+	// anything going into a mixer
+	panSpec = ParamSpec{"pan", UNIT_PAN, -1, 1, 0, false}
+	// how much quieter a centered source is, picked from the menu of
+	// inputs and mixers: 0 dB is a plain balance control, -3 dB keeps
+	// the power constant, -6 dB the sum of the channels
+	lawSpec = ParamSpec{"pan law", UNIT_DB, -6, 0, -3, false}
+	panLaws = []float64{0, -3, -4.5, -6}
+)
+
+// the ways a router node can shuffle channels around: keep one
+// side of a dual mono recording, swap the sides, sum them to mono,
+// or split the first channel out to both sides
+var routerModes = []string{"left", "right", "swap", "sum", "split"}
+
+const (
+	PAN_LAW_PREFIX = "pan law: "
+	ROUTER_PREFIX = "route: "
+)
+
+// panGains returns the left and right gain for a pan position from
+// -1 to 1, following the given pan law
+func panGains(pan, law float64) (float64, float64) {
+	if law > -0.01 {
+		return math.Min(1, 1 - pan), math.Min(1, 1 + pan)
+	}
+	theta := (pan + 1) * math.Pi / 4
+	k := law / gainToDb(math.Sqrt2 / 2)
+	return math.Pow(math.Cos(theta), k), math.Pow(math.Sin(theta), k)
+}
+
+// panState remembers the last gains, so a pan move glides across
+// a block instead of stepping
+type panState struct {
+	l, r float64
+	started bool
+}
+
+// apply pans a mono or stereo block starting at time t into a stereo
+// one. An automated pan is followed frame by frame. The law is only
+// for mono; stereo is balanced, so it keeps its level in the middle.
+func (ps *panState) apply(out, in *Block, pan *Param, law, t float64) {
+	if in.Channels != 1 {
+		law = 0
+	}
+	l, r := panGains(pan.Get(), law)
+	if !ps.started {
+		ps.l, ps.r, ps.started = l, r, true
+	}
+	automated := pan.Automated()
+	out.Frames = in.Frames
+	data, src := out.Samples(), in.Samples()
+	for f := 0; f < in.Frames; f++ {
+		x := float64(f + 1) / float64(in.Frames)
+		gl := float32(ps.l + (l - ps.l) * x)
+		gr := float32(ps.r + (r - ps.r) * x)
+		if automated {
+			al, ar := panGains(pan.At(t + float64(f) / in.Rate), law)
+			gl, gr = float32(al), float32(ar)
+			l, r = al, ar
+		}
+		left, right := src[f * in.Channels], src[f * in.Channels + in.Channels - 1]
+		data[f * 2] = left * gl
+		data[f * 2 + 1] = right * gr
+	}
+	ps.l, ps.r = l, r
+}
+
+// canPan is true for formats that can be panned into stereo
+func canPan(f Format) bool {
+	return f.Channels == 1 || f.Channels == 2
+}
+
+// panner pans whatever goes through a link into a mixer
+type panner struct {
+	src puller
+	node, mixer *Node
+	state panState
+	block *Block
+}
+
+func newPanner(src puller, node, mixer *Node, format Format) *panner {
+	return &panner{src: src, node: node, mixer: mixer, block: NewBlock(Format{format.Rate, 2})}
+}
+
+func (p *panner) pull(e *Engine, g *graph) *Block {
+	in := p.src.pull(e, g)
+	p.state.apply(p.block, in, p.node.pan, p.mixer.law.Get(), g.time - p.src.latency())
+	return p.block
+}
+
+func (p *panner) prepare(e *Engine) {
+	p.src.prepare(e)
+}
+
+func (p *panner) latency() float64 {
+	return p.src.latency()
+}
+
+func (p *panner) close() {
+	p.src.close()
+}
+
+// routerMode returns what a router node does with the channels
+func (node *Node) routerMode() string {
+	if len(node.args) == 1 {
+		return node.args[0]
+	}
+	return "left"
+}
+
+// routedFormat returns the format coming out of a router
+func routedFormat(mode string, in Format) Format {
+	switch mode {
+	case "left", "right", "sum":
+		return Format{in.Rate, 1}
+	case "split":
+		return Format{in.Rate, 2}
+	}
+	return in
+}
+
+// route shuffles the channels of a block into another one
+func route(mode string, out, in *Block) {
+	out.Frames = in.Frames
+	data, src := out.Samples(), in.Samples()
+	ch := in.Channels
+	for f := 0; f < in.Frames; f++ {
+		frame := src[f * ch:(f + 1) * ch]
+		switch mode {
+		case "left":
+			data[f] = frame[0]
+		case "right":
+			data[f] = frame[ch - 1]
+		case "sum":
+			var sum float32
+			for _, s := range frame {
+				sum += s
+			}
+			data[f] = sum / float32(ch)
+		case "split":
+			data[f * 2] = frame[0]
+			data[f * 2 + 1] = frame[0]
+		case "swap":
+			for k := range frame {
+				data[f * ch + k] = frame[ch - 1 - k]
+			}
+		}
+	}
+}
+
+func lawEntries() []string {
+	entries := make([]string, len(panLaws))
+	for i, law := range panLaws {
+		entries[i] = fmt.Sprintf("%s%g dB", PAN_LAW_PREFIX, law)
+	}
+	return entries
+}
+
+// setLaw handles a pan law menu entry, returning false for anything else
+func setLaw(n *Node, entry string) bool {
+	if !strings.HasPrefix(entry, PAN_LAW_PREFIX) {
+		return false
+	}
+	text := strings.TrimSuffix(strings.TrimPrefix(entry, PAN_LAW_PREFIX), " dB")
+	if law, err := strconv.ParseFloat(text, 64); err == nil {
+		n.law.Set(law)
+	}
+	return true
+}
+
+// mixerMenu fills in the right-click menu of a mixer node
+func (canvas *CanvasPane) mixerMenu(n *Node) {
+	n.menu.Init(canvas.rsc.renderer, n.Pos, lawEntries(), canvas.rsc.TitleFont)
+	n.menu.OnClick(func(entry *MenuEntry) {
+		setLaw(n, entry.Text)
+	})
+}
+
+func (canvas *CanvasPane) newRouter(x, y int32) *Node {
+	n := canvas.newNode("router", "router", hexcolor(0x15f0e1), x, y)
+	canvas.setRouterMode(n, "left")
+	entries := make([]string, len(routerModes))
+	for i, m := range routerModes {
+		entries[i] = ROUTER_PREFIX + m
+	}
+	n.menu.Init(canvas.rsc.renderer, n.Pos, entries, canvas.rsc.TitleFont)
+	n.menu.OnClick(func(entry *MenuEntry) {
+		canvas.setRouterMode(n, strings.TrimPrefix(entry.Text, ROUTER_PREFIX))
+	})
+	return n
+}
+
+func (canvas *CanvasPane) setRouterMode(n *Node, mode string) {
+	n.args = []string{mode}
+	n.SetLabel(canvas.rsc.renderer, "router: " + mode)
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync/atomic"
+)
+
+// Units for node parameters. The unit decides how a value is
+// displayed, and together with Log how it maps onto a control.
+const (
+	UNIT_NONE = iota
+	UNIT_DB
+	UNIT_HZ
+	UNIT_MS
+	UNIT_RATIO
+	UNIT_PAN
+)
+
+// ParamSpec describes a named, numeric node parameter.
+type ParamSpec struct {
+	Name string
+	Unit int
+	Min, Max, Default float64
+	// Log makes controls move in equal steps per octave rather than
+	// per unit. Min must be above zero.
+	Log bool
+}
+
+// Param is a live parameter value. The value is stored atomically
+// since it is written by the UI and read by the audio side.
+type Param struct {
+	ParamSpec
+	bits uint64
+	// holds an Envelope, swapped whole by the UI when edited
+	env atomic.Value
+}
+
+// Breakpoint is a point on an automation envelope, at a time in
+// seconds from the start of the timeline
+type Breakpoint struct {
+	Time float64 `json:"time"`
+	Value float64 `json:"value"`
+}
+
+// Envelope is a sorted list of breakpoints with straight lines
+// between them. Before the first and after the last point it holds
+// their value.
+type Envelope []Breakpoint
+
+func NewParam(spec ParamSpec) *Param {
+	p := &Param{ParamSpec: spec}
+	p.Reset()
+	return p
+}
+
+func (p *Param) Get() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&p.bits))
+}
+
+// Set the value, clamped to the parameter range
+func (p *Param) Set(value float64) {
+	value = math.Max(p.Min, math.Min(p.Max, value))
+	atomic.StoreUint64(&p.bits, math.Float64bits(value))
+}
+
+func (p *Param) Reset() {
+	p.Set(p.Default)
+}
+
+// Norm returns the control position of the value, from 0 to 1
+func (p *Param) Norm() float64 {
+	return p.NormOf(p.Get())
+}
+
+// SetNorm sets the value from a control position from 0 to 1
+func (p *Param) SetNorm(t float64) {
+	p.Set(p.FromNorm(t))
+}
+
+// NormOf returns the control position of any value in range
+func (p *Param) NormOf(v float64) float64 {
+	if p.Log {
+		return math.Log(v / p.Min) / math.Log(p.Max / p.Min)
+	}
+	return (v - p.Min) / (p.Max - p.Min)
+}
+
+// FromNorm returns the value at a control position from 0 to 1
+func (p *Param) FromNorm(t float64) float64 {
+	t = math.Max(0, math.Min(1, t))
+	if p.Log {
+		return p.Min * math.Pow(p.Max / p.Min, t)
+	}
+	return p.Min + (p.Max - p.Min) * t
+}
+
+// Envelope returns the automation of the parameter, nil if there is none.
+// It must not be modified, use SetEnvelope with a copy.
+func (p *Param) Envelope() Envelope {
+	env, _ := p.env.Load().(Envelope)
+	return env
+}
+
+// SetEnvelope replaces the automation, sorting the points by time.
+// An empty envelope turns automation off.
+func (p *Param) SetEnvelope(env Envelope) {
+	env = append(Envelope(nil), env...)
+	for i := range env {
+		env[i].Value = math.Max(p.Min, math.Min(p.Max, env[i].Value))
+	}
+	sort.SliceStable(env, func(i, j int) bool { return env[i].Time < env[j].Time })
+	p.env.Store(env)
+}
+
+// Automated is true when the parameter follows an envelope
+func (p *Param) Automated() bool {
+	return len(p.Envelope()) > 0
+}
+
+// At returns the value at a time in seconds, from the envelope if
+// there is one
+func (p *Param) At(t float64) float64 {
+	env := p.Envelope()
+	if len(env) == 0 {
+		return p.Get()
+	}
+	return env.At(t)
+}
+
+func (env Envelope) At(t float64) float64 {
+	i := sort.Search(len(env), func(i int) bool { return env[i].Time > t })
+	if i == 0 {
+		return env[0].Value
+	}
+	if i == len(env) {
+		return env[i - 1].Value
+	}
+	a, b := env[i - 1], env[i]
+	return a.Value + (b.Value - a.Value) * (t - a.Time) / (b.Time - a.Time)
+}
+
+// Format returns the value as text for a readout
+func (p *Param) Format() string {
+	v := p.Get()
+	switch p.Unit {
+	case UNIT_DB:
+		return fmt.Sprintf("%+.1f dB", v)
+	case UNIT_HZ:
+		if v >= 1000 {
+			return fmt.Sprintf("%.2f kHz", v / 1000)
+		}
+		return fmt.Sprintf("%.0f Hz", v)
+	case UNIT_MS:
+		if v >= 1000 {
+			return fmt.Sprintf("%.2f s", v / 1000)
+		}
+		return fmt.Sprintf("%.0f ms", v)
+	case UNIT_RATIO:
+		return fmt.Sprintf("%.1f:1", v)
+	case UNIT_PAN:
+		if v < -0.005 {
+			return fmt.Sprintf("L%.0f", -v * 100)
+		} else if v > 0.005 {
+			return fmt.Sprintf("R%.0f", v * 100)
+		}
+		return "C"
+	}
+	return fmt.Sprintf("%.2f", v)
+}
+
+// Text renders the value the way SoX expects it on a command line
+func (p *Param) Text() string {
+	v := p.Get()
+	switch p.Unit {
+	case UNIT_DB:
+		return fmt.Sprintf("%.2f", v)
+	case UNIT_MS:
+		return fmt.Sprintf("%.4f", v / 1000)
+	}
+	return fmt.Sprintf("%g", v)
+}
+
+func makeParams(specs []ParamSpec) []*Param {
+	params := make([]*Param, 0, len(specs))
+	for _, s := range specs {
+		params = append(params, NewParam(s))
+	}
+	return params
+}
+
+// copyParams returns unautomated copies of params at their current
+// values, for something that sets them itself
+func copyParams(params []*Param) []*Param {
+	out := make([]*Param, 0, len(params))
+	for _, p := range params {
+		c := NewParam(p.ParamSpec)
+		c.Set(p.Get())
+		out = append(out, c)
+	}
+	return out
+}
+
+// EffectDef describes the parameters of an effect and how
+// to turn them into SoX effect arguments. Effects SoX doesn't have
+// leave Args out.
+type EffectDef struct {
+	Params []ParamSpec
+	Args func(p []*Param) []string
+}
+
+var inputParams = []ParamSpec{
+	{"gain", UNIT_DB, -40, 12, 0, false},
+}
+
+var effectDefs = map[string]EffectDef{
+	"vol": {
+		[]ParamSpec{{"gain", UNIT_DB, -40, 12, 0, false}},
+		func(p []*Param) []string {
+			return []string{p[0].Text() + "dB"}
+		},
+	},
+	"highpass": {
+		[]ParamSpec{{"frequency", UNIT_HZ, 20, 2000, 80, true}},
+		func(p []*Param) []string {
+			return []string{p[0].Text()}
+		},
+	},
+	"lowpass": {
+		[]ParamSpec{{"frequency", UNIT_HZ, 1000, 20000, 12000, true}},
+		func(p []*Param) []string {
+			return []string{p[0].Text()}
+		},
+	},
+	"equalizer": {
+		[]ParamSpec{
+			{"frequency", UNIT_HZ, 20, 20000, 1000, true},
+			{"width", UNIT_NONE, 0.1, 10, 1, true},
+			{"gain", UNIT_DB, -24, 24, 0, false},
+		},
+		func(p []*Param) []string {
+			return []string{p[0].Text(), p[1].Text() + "q", p[2].Text()}
+		},
+	},
+	"compand": {
+		[]ParamSpec{
+			{"attack", UNIT_MS, 1, 500, 10, true},
+			{"release", UNIT_MS, 10, 2000, 200, true},
+			{"threshold", UNIT_DB, -60, 0, -20, false},
+			{"ratio", UNIT_RATIO, 1, 20, 4, true},
+		},
+		func(p []*Param) []string {
+			// above the threshold, output rises 1/ratio dB per input dB
+			thr := p[2].Get()
+			top := thr - thr / p[3].Get()
+			return []string{
+				p[0].Text() + "," + p[1].Text(),
+				fmt.Sprintf("6:-90,-90,%.2f,%.2f,0,%.2f", thr, thr, top),
+			}
+		},
+	},
+	"delay": {
+		[]ParamSpec{{"delay", UNIT_MS, 0, 5000, 0, false}},
+		func(p []*Param) []string {
+			return []string{p[0].Text()}
+		},
+	},
+	"denoise": {denoiseParams, nil},
+	"deess": {deessParams, nil},
+	"deplosive": {deplosiveParams, nil},
+	"declick": {declickParams, nil},
+	"declip": {declipParams, nil},
+}
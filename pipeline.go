@@ -0,0 +1,90 @@
+package main
+
+// frames in a block, the unit everything in the pipeline works on
+const BLOCK_SIZE = 1024
+
+// Format is the sample rate and channel layout of a stream.
+// Channels are interleaved, left before right.
+type Format struct {
+	Rate float64
+	Channels int
+}
+
+// Block is up to BLOCK_SIZE frames of interleaved samples between -1 and 1
+type Block struct {
+	Format
+	Data []float32
+	Frames int
+}
+
+// Processor is an effect in the pipeline. It works on a block in
+// place, and keeps whatever state it needs between blocks. Its
+// parameters are read as it goes, so changes apply from the next block.
+type Processor interface {
+	Process(block *Block)
+}
+
+// Closer is implemented by processors holding on to resources
+type Closer interface {
+	Close()
+}
+
+// Latency is implemented by processors that hold their signal back.
+// The engine delays whatever is mixed with them to match.
+type Latency interface {
+	// Latency returns how many frames late the signal comes out
+	Latency() int
+}
+
+// latencyOf returns how many frames a processor holds its signal back
+func latencyOf(p Processor) int {
+	if l, ok := p.(Latency); ok {
+		return l.Latency()
+	}
+	return 0
+}
+
+func NewBlock(format Format) *Block {
+	return &Block{format, make([]float32, BLOCK_SIZE * format.Channels), 0}
+}
+
+// Samples returns the filled part of the block
+func (b *Block) Samples() []float32 {
+	return b.Data[:b.Frames * b.Channels]
+}
+
+// Clear empties the block, ready to mix into
+func (b *Block) Clear(frames int) {
+	b.Frames = frames
+	data := b.Samples()
+	for i := range data {
+		data[i] = 0
+	}
+}
+
+// Extend pads the block with silence up to the given length
+func (b *Block) Extend(frames int) {
+	if frames <= b.Frames {
+		return
+	}
+	extra := b.Data[b.Frames * b.Channels : frames * b.Channels]
+	for i := range extra {
+		extra[i] = 0
+	}
+	b.Frames = frames
+}
+
+// Add mixes another block of the same format into this one
+func (b *Block) Add(other *Block) {
+	b.Extend(other.Frames)
+	data := b.Data
+	for i, s := range other.Samples() {
+		data[i] += s
+	}
+}
+
+// CopyFrom makes the block a copy of another one of the same format
+func (b *Block) CopyFrom(other *Block) {
+	b.Frames = other.Frames
+	copy(b.Data, other.Samples())
+}
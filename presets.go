@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/krig/Go-SDL2/sdl"
+)
+
+// effect menu entries for chain and parameter presets start with these
+const (
+	CHAIN_PREFIX = "chain: "
+	PRESET_PREFIX = "preset: "
+)
+
+// ChainPreset is a named group of linked nodes, saved as JSON in
+// the chains preset directory
+type ChainPreset struct {
+	Name string `json:"name"`
+	Nodes []NodeSpec `json:"nodes"`
+}
+
+// presetDir returns the directory holding presets of the given kind.
+// PODCAST_STUDIO_PRESETS overrides the default of a directory under
+// the user config directory.
+func presetDir(kind string) string {
+	if dir := os.Getenv("PODCAST_STUDIO_PRESETS"); dir != "" {
+		return filepath.Join(dir, kind)
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = "."
+	}
+	return filepath.Join(dir, "podcast-studio", "presets", kind)
+}
+
+// presetFile turns a preset name into a file name
+func presetFile(dir, name string) string {
+	clean := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || r < ' ' {
+			return '_'
+		}
+		return r
+	}, name)
+	return filepath.Join(dir, clean + ".json")
+}
+
+func writeJSON(filename string, value interface{}) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(value, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+func readJSON(filename string, value interface{}) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+// presetFiles lists the JSON files in a preset directory
+func presetFiles(dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		log.Println(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func loadChainPresets() []ChainPreset {
+	var presets []ChainPreset
+	for _, f := range presetFiles(presetDir("chains")) {
+		var p ChainPreset
+		if err := readJSON(f, &p); err != nil {
+			log.Println(f, err)
+			continue
+		}
+		presets = append(presets, p)
+	}
+	return presets
+}
+
+func saveChainPreset(preset ChainPreset) error {
+	return writeJSON(presetFile(presetDir("chains"), preset.Name), preset)
+}
+
+// SaveChain asks for a name and saves the selected group as a chain preset
+func (canvas *CanvasPane) SaveChain() {
+	nodes := canvas.groupNodes()
+	if len(nodes) == 0 {
+		log.Println("Select some nodes to save as a chain.")
+		return
+	}
+	canvas.askText("Save chain as", "", func(name string) {
+		preset := ChainPreset{name, copyNodes(nodes)}
+		if err := saveChainPreset(preset); err != nil {
+			log.Println(err)
+			return
+		}
+		canvas.chains = loadChainPresets()
+	})
+}
+
+// EffectPreset is a named set of settings for one effect, saved as
+// JSON in a directory per effect under the effects preset directory
+type EffectPreset struct {
+	Name string `json:"name"`
+	Effect string `json:"effect"`
+	Args []string `json:"args,omitempty"`
+	Params map[string]float64 `json:"params,omitempty"`
+}
+
+// factory presets for voice work, shown before the user's own
+var factoryPresets = []EffectPreset{
+	{"Voice rumble cut", "highpass", nil, map[string]float64{"frequency": 80}},
+	{"Voice plosive cut", "highpass", nil, map[string]float64{"frequency": 120}},
+	{"Voice hiss cut", "lowpass", nil, map[string]float64{"frequency": 12000}},
+	{"Voice mud cut", "equalizer", nil, map[string]float64{"frequency": 300, "width": 1.4, "gain": -3}},
+	{"Voice presence", "equalizer", nil, map[string]float64{"frequency": 4000, "width": 1, "gain": 3}},
+	{"Voice gentle leveler", "compand", nil, map[string]float64{"attack": 20, "release": 300, "threshold": -24, "ratio": 2}},
+	{"Voice broadcast", "compand", nil, map[string]float64{"attack": 5, "release": 150, "threshold": -30, "ratio": 4}},
+	{"Voice -6dB", "vol", nil, map[string]float64{"gain": -6}},
+}
+
+// effectPresets returns the factory and user presets for an effect.
+// A user preset replaces a factory preset with the same name.
+func effectPresets(effect string) []EffectPreset {
+	if effect == "" {
+		return nil
+	}
+	var user []EffectPreset
+	names := make(map[string]bool)
+	for _, f := range presetFiles(filepath.Join(presetDir("effects"), effect)) {
+		var p EffectPreset
+		if err := readJSON(f, &p); err != nil {
+			log.Println(f, err)
+			continue
+		}
+		p.Effect = effect
+		user = append(user, p)
+		names[p.Name] = true
+	}
+	var presets []EffectPreset
+	for _, p := range factoryPresets {
+		if p.Effect == effect && !names[p.Name] {
+			presets = append(presets, p)
+		}
+	}
+	return append(presets, user...)
+}
+
+func saveEffectPreset(preset EffectPreset) error {
+	return writeJSON(presetFile(filepath.Join(presetDir("effects"), preset.Effect), preset.Name), preset)
+}
+
+// SavePreset asks for a name and saves the settings of an effect node
+func (canvas *CanvasPane) SavePreset(node *Node) {
+	preset := EffectPreset{
+		Effect: node.effect,
+		Args: append([]string(nil), node.args...),
+		Params: make(map[string]float64),
+	}
+	for _, p := range node.params {
+		preset.Params[p.Name] = p.Get()
+	}
+	canvas.askText("Save " + node.effect + " preset as", "", func(name string) {
+		preset.Name = name
+		if err := saveEffectPreset(preset); err != nil {
+			log.Println(err)
+			return
+		}
+		for _, n := range canvas.nodes {
+			if n.name == "effect" && n.effect == node.effect {
+				canvas.effectMenu(n)
+			}
+		}
+	})
+}
+
+// ApplyPreset sets the parameters of an effect node from a preset
+func (canvas *CanvasPane) ApplyPreset(node *Node, preset EffectPreset) {
+	if node.effect != preset.Effect {
+		canvas.setEffect(node, preset.Effect)
+	}
+	if len(preset.Args) > 0 {
+		node.args = append([]string(nil), preset.Args...)
+	}
+	for name, v := range preset.Params {
+		if p := node.Param(name); p != nil {
+			p.Set(v)
+			canvas.ParamChanged(node, p)
+		} else {
+			log.Println("Unknown parameter:", name)
+		}
+	}
+}
+
+// chainEnds finds where the links come into and go out of a group:
+// the node nothing in the group links to, and the one linking nowhere
+func chainEnds(nodes []*Node) (head, tail *Node) {
+	in := make(map[*Node]bool)
+	member := make(map[*Node]bool)
+	for _, n := range nodes {
+		member[n] = true
+	}
+	for _, n := range nodes {
+		if member[n.next] {
+			in[n.next] = true
+		}
+	}
+	for _, n := range nodes {
+		if !in[n] && head == nil {
+			head = n
+		}
+		if !member[n.next] && tail == nil {
+			tail = n
+		}
+	}
+	return head, tail
+}
+
+// InsertChain replaces the placeholder node with the nodes of the
+// preset, keeping the placeholder's links. An unlinked placeholder
+// gets spliced into the link it was dropped on, if any.
+func (canvas *CanvasPane) InsertChain(placeholder *Node, preset ChainPreset) {
+	nodes := canvas.pasteNodes(preset.Nodes, placeholder.Pos.X, placeholder.Pos.Y)
+	var inserted []*Node
+	for _, n := range nodes {
+		if n != nil {
+			inserted = append(inserted, n)
+		}
+	}
+	if len(inserted) == 0 {
+		return
+	}
+	head, tail := chainEnds(inserted)
+	linked := placeholder.next != nil
+	for _, n := range canvas.nodes {
+		if n.next == placeholder {
+			n.next = head
+			linked = true
+		}
+	}
+	tail.next = placeholder.next
+	canvas.RemoveNode(placeholder)
+	if !linked {
+		canvas.SpliceOnLink(inserted)
+	}
+	canvas.selectNodes(inserted)
+}
+
+// linkUnder returns the node whose link runs underneath the given
+// box, ignoring links to and from the nodes in skip
+func (canvas *CanvasPane) linkUnder(box sdl.Rect, skip map[*Node]bool) *Node {
+	for _, n := range canvas.nodes {
+		if n.next == nil || skip[n] || skip[n.next] {
+			continue
+		}
+		obstacles := make([]sdl.Rect, 0, len(canvas.nodes))
+		for _, o := range canvas.nodes {
+			if o != n && o != n.next && !skip[o] {
+				obstacles = append(obstacles, o.GetPos())
+			}
+		}
+		path := routeLink(n.GetPos(), n.next.GetPos(), obstacles, canvas.offsets[n])
+		if !pathClear(path, []sdl.Rect{box}) {
+			return n
+		}
+	}
+	return nil
+}
+
+// SpliceOnLink inserts a group of nodes into the link they were
+// dropped on. Only groups that aren't linked to anything outside
+// themselves are spliced.
+func (canvas *CanvasPane) SpliceOnLink(nodes []*Node) {
+	member := make(map[*Node]bool)
+	var box sdl.Rect
+	for _, n := range nodes {
+		member[n] = true
+		box = unionRect(box, n.GetPos())
+	}
+	for _, n := range canvas.nodes {
+		if !member[n] && member[n.next] {
+			return
+		}
+	}
+	head, tail := chainEnds(nodes)
+	if head == nil || tail == nil || tail.next != nil || head.name == "input" || tail.name == "output" {
+		return
+	}
+	from := canvas.linkUnder(box, member)
+	if from == nil {
+		return
+	}
+	log.Println("Splicing into the link from", from.label.Text, "to", from.next.label.Text)
+	tail.next = from.next
+	from.next = head
+}
+
+// RemoveNode deletes a node from the canvas along with links to it
+func (canvas *CanvasPane) RemoveNode(node *Node) {
+	nodes := make([]*Node, 0, len(canvas.nodes))
+	for _, n := range canvas.nodes {
+		if n.next == node {
+			n.next = nil
+		}
+		if n != node {
+			nodes = append(nodes, n)
+		}
+	}
+	canvas.nodes = nodes
+	canvas.new_link = nil
+	delete(canvas.group, node)
+	if canvas.reference == node {
+		canvas.reference = nil
+	}
+	if canvas.selected == node {
+		canvas.Select(nil)
+	}
+	node.label.Destroy()
+	node.menu.Destroy()
+}
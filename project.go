@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	PROJECT_VERSION = 1
+	PROJECT_EXT = ".json"
+)
+
+// Project is everything on the canvas: the nodes and their links,
+// settings, automation and clips, with the chapters and how the mix
+// is exported. Audio files are only referred to, so edits never
+// change them.
+type Project struct {
+	Version int `json:"version"`
+	// where the top left node sits on the canvas
+	X int32 `json:"x"`
+	Y int32 `json:"y"`
+	Nodes []NodeSpec `json:"nodes"`
+	Chapters []Chapter `json:"chapters,omitempty"`
+	Export *Export `json:"export,omitempty"`
+}
+
+// isProject tells project files from audio files
+func isProject(filename string) bool {
+	return strings.ToLower(filepath.Ext(filename)) == PROJECT_EXT
+}
+
+func (canvas *CanvasPane) SaveProject(filename string) {
+	bounds := canvas.Bounds()
+	export := canvas.export
+	project := Project{PROJECT_VERSION, bounds.X, bounds.Y, copyNodes(canvas.nodes), canvas.chapters, &export}
+	if err := writeJSON(filename, project); err != nil {
+		log.Println(err)
+		return
+	}
+	canvas.project = filename
+	log.Println("Saved", filename)
+}
+
+// LoadProject replaces everything on the canvas with a saved project
+func (canvas *CanvasPane) LoadProject(filename string) {
+	var project Project
+	if err := readJSON(filename, &project); err != nil {
+		log.Println(err)
+		return
+	}
+	if project.Version > PROJECT_VERSION {
+		log.Println(fmt.Sprintf("%s is from a newer version (%d)", filename, project.Version))
+		return
+	}
+	canvas.Stop()
+	for len(canvas.nodes) > 0 {
+		canvas.RemoveNode(canvas.nodes[0])
+	}
+	canvas.pasteNodes(project.Nodes, project.X, project.Y)
+	canvas.chapters = project.Chapters
+	canvas.export = defaultExport()
+	if project.Export != nil {
+		canvas.export = *project.Export
+	}
+	canvas.project = filename
+	canvas.FitToContent()
+	log.Println("Opened", filename)
+}
+
+// projectName is what the project dialogs suggest
+func (canvas *CanvasPane) projectName() string {
+	if canvas.project != "" {
+		return canvas.project
+	}
+	return "project" + PROJECT_EXT
+}
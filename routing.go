@@ -0,0 +1,257 @@
+package main
+
+import (
+	"math"
+
+	"github.com/krig/Go-SDL2/sdl"
+)
+
+const (
+	// nodes snap to this grid
+	GRID_SIZE = 16
+	// distance between a box and the lanes routed around it
+	LANE_PAD = int32(8)
+	// separation between links sharing a lane
+	LANE_SPREAD = int32(3)
+	// how far to look for a free lane, in grid steps
+	LANE_SEARCH = 8
+)
+
+// each source chain gets a color from the color scheme
+var chainPalette = []uint32{0x15f0e1, 0xff3015, 0x5be33b, 0xffe018, 0x694ae9}
+
+type point struct {
+	X, Y int32
+}
+
+func snap(v float64) float64 {
+	return math.Floor(v / GRID_SIZE + 0.5) * GRID_SIZE
+}
+
+// segmentHits is true if the horizontal or vertical segment
+// from a to b passes through the inside of rect
+func segmentHits(a, b point, rect sdl.Rect) bool {
+	x0, x1 := a.X, b.X
+	if x0 > x1 {
+		x0, x1 = x1, x0
+	}
+	y0, y1 := a.Y, b.Y
+	if y0 > y1 {
+		y0, y1 = y1, y0
+	}
+	return x1 > rect.X && x0 < rect.X + rect.W && y1 > rect.Y && y0 < rect.Y + rect.H
+}
+
+func pathClear(path []point, obstacles []sdl.Rect) bool {
+	for i := 1; i < len(path); i++ {
+		for _, o := range obstacles {
+			if segmentHits(path[i-1], path[i], o) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// laneCandidates returns grid lanes starting at mid and moving
+// outwards in both directions, keeping between lo and hi
+func laneCandidates(mid, lo, hi int32) []int32 {
+	mid = int32(snap(float64(mid)))
+	lanes := []int32{}
+	for i := 0; i <= LANE_SEARCH; i++ {
+		for _, l := range []int32{mid + int32(i)*GRID_SIZE, mid - int32(i)*GRID_SIZE} {
+			if l >= lo && l <= hi {
+				lanes = append(lanes, l)
+			}
+			if i == 0 {
+				break
+			}
+		}
+	}
+	if len(lanes) == 0 {
+		lanes = append(lanes, (lo + hi) / 2)
+	}
+	return lanes
+}
+
+// routeLink returns the corners of an orthogonal path from the right
+// side of one box to the left side of another. Vertical and horizontal
+// runs are placed in grid lanes clear of the obstacles if one can be
+// found, shifted by offset to keep different chains apart.
+func routeLink(from, to sdl.Rect, obstacles []sdl.Rect, offset int32) []point {
+	start := point{from.X + from.W, from.Y + from.H/2}
+	end := point{to.X, to.Y + to.H/2}
+
+	if end.X - start.X >= LANE_PAD*2 {
+		var fallback []point
+		for _, lx := range laneCandidates((start.X + end.X) / 2, start.X + LANE_PAD, end.X - LANE_PAD) {
+			lx += offset
+			path := []point{start, {lx, start.Y}, {lx, end.Y}, end}
+			if pathClear(path, obstacles) {
+				return path
+			}
+			if fallback == nil {
+				fallback = path
+			}
+		}
+		return fallback
+	}
+
+	// the target is behind us: go out, around above or below, and back in
+	ax := start.X + LANE_PAD + offset
+	bx := end.X - LANE_PAD + offset
+	top := int32(math.Min(float64(from.Y), float64(to.Y))) - LANE_PAD
+	bottom := int32(math.Max(float64(from.Y + from.H), float64(to.Y + to.H))) + LANE_PAD
+	var fallback []point
+	for i := int32(0); i <= LANE_SEARCH; i++ {
+		for _, ly := range []int32{bottom + i*GRID_SIZE, top - i*GRID_SIZE} {
+			ly += offset
+			path := []point{start, {ax, start.Y}, {ax, ly}, {bx, ly}, {bx, end.Y}, end}
+			if pathClear(path, obstacles) {
+				return path
+			}
+			if fallback == nil {
+				fallback = path
+			}
+		}
+	}
+	return fallback
+}
+
+func drawPath(rend *sdl.Renderer, path []point, clr sdl.Color) {
+	rend.SetDrawColor(clr)
+	for i := 1; i < len(path); i++ {
+		a, b := path[i-1], path[i]
+		rend.DrawLine(a.X, a.Y, b.X, b.Y)
+		// double up for a 2 pixel line
+		if a.Y == b.Y {
+			rend.DrawLine(a.X, a.Y + 1, b.X, b.Y + 1)
+		} else {
+			rend.DrawLine(a.X + 1, a.Y, b.X + 1, b.Y)
+		}
+	}
+	if len(path) > 0 {
+		end := path[len(path) - 1]
+		rend.DrawLine(end.X - 5, end.Y - 3, end.X, end.Y)
+		rend.DrawLine(end.X - 5, end.Y + 4, end.X, end.Y + 1)
+	}
+}
+
+// averageColor mixes the given colors evenly
+func averageColor(colors []sdl.Color) sdl.Color {
+	var r, g, b int
+	for _, c := range colors {
+		r += int(c.R)
+		g += int(c.G)
+		b += int(c.B)
+	}
+	n := len(colors)
+	return sdl.Color{uint8(r / n), uint8(g / n), uint8(b / n), 0xff}
+}
+
+// chainColors works out what color the link out of each node gets.
+// Every input starts a chain with its own color, and where chains
+// merge the link gets a blend of their colors.
+func (canvas *CanvasPane) chainColors() (map[*Node]sdl.Color, map[*Node]int32) {
+	through := make(map[*Node][]sdl.Color)
+	lane := make(map[*Node][]int)
+	source := 0
+	for _, n := range canvas.nodes {
+		if n.name != "input" {
+			continue
+		}
+		clr := hexcolor(chainPalette[source % len(chainPalette)])
+		seen := map[*Node]bool{}
+		for m := n; m != nil && !seen[m]; m = m.next {
+			seen[m] = true
+			through[m] = append(through[m], clr)
+			lane[m] = append(lane[m], source)
+		}
+		source++
+	}
+	colors := make(map[*Node]sdl.Color)
+	offsets := make(map[*Node]int32)
+	for n, c := range through {
+		colors[n] = averageColor(c)
+		if len(c) == 1 {
+			offsets[n] = int32(lane[n][0] % len(chainPalette) - len(chainPalette)/2) * LANE_SPREAD
+		}
+	}
+	return colors, offsets
+}
+
+// linkPath routes the link out of node around the other nodes,
+// in canvas coordinates
+func (canvas *CanvasPane) linkPath(node *Node) []point {
+	obstacles := make([]sdl.Rect, 0, len(canvas.nodes))
+	for _, o := range canvas.nodes {
+		if o != node && o != node.next {
+			obstacles = append(obstacles, o.GetPos())
+		}
+	}
+	return routeLink(node.GetPos(), node.next.GetPos(), obstacles, canvas.offsets[node])
+}
+
+// DrawLinks draws every link on the canvas, routed in lanes.
+// Colors are worked out in UpdateAnimations.
+func (canvas *CanvasPane) DrawLinks(rend *sdl.Renderer) {
+	for _, n := range canvas.nodes {
+		if n.next == nil {
+			continue
+		}
+		path := canvas.linkPath(n)
+		for i, p := range path {
+			path[i].X, path[i].Y = canvas.cam.ToScreen(float64(p.X), float64(p.Y))
+		}
+		drawPath(rend, path, n.linkfade.Get())
+	}
+}
+
+// distToSegment returns the distance from p to the segment from a to b
+func distToSegment(p, a, b point) float64 {
+	dx, dy := float64(b.X - a.X), float64(b.Y - a.Y)
+	t := 0.0
+	if l := dx*dx + dy*dy; l > 0 {
+		t = math.Max(0, math.Min(1, (float64(p.X - a.X)*dx + float64(p.Y - a.Y)*dy) / l))
+	}
+	return math.Hypot(float64(a.X) + t*dx - float64(p.X), float64(a.Y) + t*dy - float64(p.Y))
+}
+
+// linkAt returns the node whose outgoing link passes within a few
+// pixels of the given screen position, or nil
+func (canvas *CanvasPane) linkAt(x, y int32) *Node {
+	wx, wy := canvas.cam.ToWorld(x, y)
+	p := point{int32(wx), int32(wy)}
+	reach := 4 / canvas.cam.Zoom
+	for _, n := range canvas.nodes {
+		if n.next == nil {
+			continue
+		}
+		path := canvas.linkPath(n)
+		for i := 1; i < len(path); i++ {
+			if distToSegment(p, path[i-1], path[i]) <= reach {
+				return n
+			}
+		}
+	}
+	return nil
+}
+
+// DrawGrid marks the grid points on the canvas background,
+// skipping some when zoomed out too far to tell them apart
+func (canvas *CanvasPane) DrawGrid(rend *sdl.Renderer) {
+	rend.SetDrawColor(darken(canvas.rsc.BackgroundColor, 24))
+	step := float64(GRID_SIZE)
+	for step * canvas.cam.Zoom < 8 {
+		step *= 2
+	}
+	view := canvas.cam.Visible()
+	x0 := math.Floor(float64(view.X) / step) * step
+	y0 := math.Floor(float64(view.Y) / step) * step
+	for y := y0; y <= float64(view.Y + view.H); y += step {
+		for x := x0; x <= float64(view.X + view.W); x += step {
+			sx, sy := canvas.cam.ToScreen(x, y)
+			rend.DrawPoint(sx, sy)
+		}
+	}
+}
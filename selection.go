@@ -0,0 +1,308 @@
+package main
+
+import (
+	"log"
+
+	"github.com/krig/Go-SDL2/sdl"
+)
+
+// NodeSpec is a copy of a node's settings and its place relative to
+// the group it was copied with, leaving out its identity. Links only
+// point within the group.
+type NodeSpec struct {
+	Name string `json:"name"`
+	Effect string `json:"effect,omitempty"`
+	Args []string `json:"args,omitempty"`
+	Params map[string]float64 `json:"params,omitempty"`
+	Envelopes map[string]Envelope `json:"envelopes,omitempty"`
+	Clips []Clip `json:"clips,omitempty"`
+	X int32 `json:"x"`
+	Y int32 `json:"y"`
+	// index of the linked node in the group, or -1
+	Next int `json:"next"`
+	Bypass bool `json:"bypass,omitempty"`
+	Mute bool `json:"mute,omitempty"`
+	Solo bool `json:"solo,omitempty"`
+	Key bool `json:"key,omitempty"`
+	Listen bool `json:"listen,omitempty"`
+	Markers bool `json:"markers,omitempty"`
+	Profile *NoiseProfile `json:"profile,omitempty"`
+}
+
+// Select makes node the selected node, or clears the selection if nil
+func (canvas *CanvasPane) Select(node *Node) {
+	canvas.selected = node
+	canvas.panel.Bind(node)
+	canvas.group = make(map[*Node]bool)
+	if node != nil {
+		canvas.group[node] = true
+	}
+}
+
+// toggle adds or removes a node from the selected group
+func (canvas *CanvasPane) toggle(node *Node) {
+	if canvas.group == nil {
+		canvas.group = make(map[*Node]bool)
+	}
+	if canvas.group[node] {
+		delete(canvas.group, node)
+		node = nil
+	} else {
+		canvas.group[node] = true
+	}
+	canvas.selected = node
+	canvas.panel.Bind(node)
+}
+
+// PressSelect updates the selection for a left click at the given
+// screen position. Control toggles a node in and out of the group,
+// and clicking empty space starts a rubber band selection. It returns
+// false if the click shouldn't go on to the nodes.
+func (canvas *CanvasPane) PressSelect(x, y int32) bool {
+	ctrl := (sdl.GetModState() & sdl.KMOD_CTRL) != 0
+	if i := canvas.nodeAt(x, y); i >= 0 {
+		hit := canvas.nodes[i]
+		if ctrl {
+			canvas.toggle(hit)
+			return false
+		}
+		if canvas.group[hit] && len(canvas.group) > 1 {
+			canvas.selected = hit
+			canvas.panel.Bind(hit)
+		} else {
+			canvas.Select(hit)
+		}
+		return true
+	}
+	if n := canvas.linkAt(x, y); n != nil {
+		canvas.Select(n)
+		return true
+	}
+	if canvas.Pos.Contains(x, y) {
+		if !ctrl {
+			canvas.Select(nil)
+		}
+		canvas.banding = true
+		canvas.band_x, canvas.band_y = x, y
+		return false
+	}
+	return true
+}
+
+// dragGroup starts dragging the rest of the group along with
+// the selected node
+func (canvas *CanvasPane) dragGroup() {
+	if canvas.selected == nil || !canvas.selected.dragging {
+		return
+	}
+	for n := range canvas.group {
+		if !n.dragging {
+			n.StartDrag()
+		}
+	}
+}
+
+func bandRect(x0, y0, x1, y1 int32) sdl.Rect {
+	if x1 < x0 {
+		x0, x1 = x1, x0
+	}
+	if y1 < y0 {
+		y0, y1 = y1, y0
+	}
+	return sdl.Rect{x0, y0, x1 - x0, y1 - y0}
+}
+
+func rectsOverlap(a, b sdl.Rect) bool {
+	return a.X < b.X + b.W && b.X < a.X + a.W && a.Y < b.Y + b.H && b.Y < a.Y + a.H
+}
+
+// EndBand adds every node touched by the rubber band to the group
+func (canvas *CanvasPane) EndBand(x, y int32) {
+	canvas.banding = false
+	band := bandRect(canvas.band_x, canvas.band_y, x, y)
+	if canvas.group == nil {
+		canvas.group = make(map[*Node]bool)
+	}
+	for _, n := range canvas.nodes {
+		if rectsOverlap(band, canvas.cam.Rect(n.GetPos())) {
+			canvas.group[n] = true
+		}
+	}
+	if len(canvas.group) == 1 {
+		for n := range canvas.group {
+			canvas.Select(n)
+		}
+	}
+}
+
+// DrawSelection outlines the selected nodes and the rubber band
+func (canvas *CanvasPane) DrawSelection(rend *sdl.Renderer) {
+	rend.SetDrawColor(hexcolor(0xffffff))
+	for _, n := range canvas.nodes {
+		if n != canvas.selected && !canvas.group[n] {
+			continue
+		}
+		pos := canvas.cam.Rect(n.GetPos())
+		pos = sdl.Rect{pos.X - 2, pos.Y - 2, pos.W + 4, pos.H + 4}
+		rend.DrawRect(&pos)
+	}
+	if canvas.banding {
+		_, x, y := sdl.GetMouseState()
+		band := bandRect(canvas.band_x, canvas.band_y, int32(x), int32(y))
+		rend.SetDrawColor(hexcolor(0x694ae9))
+		rend.DrawRect(&band)
+	}
+}
+
+// groupNodes returns the selected group in canvas order
+func (canvas *CanvasPane) groupNodes() []*Node {
+	var nodes []*Node
+	for _, n := range canvas.nodes {
+		if canvas.group[n] {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// copyNodes describes the given nodes and the links between them
+func copyNodes(nodes []*Node) []NodeSpec {
+	var bounds sdl.Rect
+	index := make(map[*Node]int)
+	for i, n := range nodes {
+		bounds = unionRect(bounds, n.GetPos())
+		index[n] = i
+	}
+	specs := make([]NodeSpec, 0, len(nodes))
+	for _, n := range nodes {
+		spec := NodeSpec{
+			Name: n.name,
+			Effect: n.effect,
+			Args: append([]string(nil), n.args...),
+			Clips: append([]Clip(nil), n.clips...),
+			X: n.Pos.X - bounds.X,
+			Y: n.Pos.Y - bounds.Y,
+			Next: -1,
+			Bypass: n.bypass.Get(),
+			Mute: n.mute.Get(),
+			Solo: n.solo.Get(),
+			Key: n.key.Get(),
+			Listen: n.listen.Get(),
+			Markers: n.markers.Get(),
+			Profile: n.profile,
+		}
+		spec.Params = make(map[string]float64)
+		for _, p := range n.params {
+			spec.Params[p.Name] = p.Get()
+		}
+		for _, p := range []*Param{n.pan, n.law} {
+			if p.Get() != p.Default {
+				spec.Params[p.Name] = p.Get()
+			}
+		}
+		if len(spec.Params) == 0 {
+			spec.Params = nil
+		}
+		for _, p := range append(n.AllParams(), n.law) {
+			if env := p.Envelope(); len(env) > 0 {
+				if spec.Envelopes == nil {
+					spec.Envelopes = make(map[string]Envelope)
+				}
+				spec.Envelopes[p.Name] = env
+			}
+		}
+		if i, ok := index[n.next]; ok {
+			spec.Next = i
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// pasteNodes creates new nodes from specs with their top left
+// corner at the given canvas position
+func (canvas *CanvasPane) pasteNodes(specs []NodeSpec, x, y int32) []*Node {
+	nodes := make([]*Node, len(specs))
+	for i, spec := range specs {
+		n := canvas.makeNode(spec.Name, x + spec.X, y + spec.Y)
+		if n == nil {
+			continue
+		}
+		if spec.Effect != "" {
+			canvas.setEffect(n, spec.Effect)
+		}
+		if spec.Name == "input" && len(spec.Clips) > 0 {
+			format, _ := probeFile(spec.Clips[0].File)
+			canvas.setClips(n, append([]Clip(nil), spec.Clips...), format)
+		} else if spec.Name == "input" && len(spec.Args) == 1 {
+			canvas.setInputFile(n, spec.Args[0])
+		} else if spec.Name == "router" && len(spec.Args) == 1 {
+			canvas.setRouterMode(n, spec.Args[0])
+		} else {
+			n.args = append([]string(nil), spec.Args...)
+		}
+		for name, v := range spec.Params {
+			if p := n.Param(name); p != nil {
+				p.Set(v)
+			} else {
+				log.Println("Unknown parameter:", name)
+			}
+		}
+		for name, env := range spec.Envelopes {
+			if p := n.Param(name); p != nil {
+				p.SetEnvelope(env)
+			}
+		}
+		n.bypass.Set(spec.Bypass)
+		n.mute.Set(spec.Mute)
+		n.solo.Set(spec.Solo)
+		n.key.Set(spec.Key)
+		n.listen.Set(spec.Listen)
+		n.markers.Set(spec.Markers)
+		n.profile = spec.Profile
+		nodes[i] = n
+	}
+	for i, spec := range specs {
+		if nodes[i] != nil && spec.Next >= 0 && spec.Next < len(nodes) {
+			nodes[i].next = nodes[spec.Next]
+		}
+	}
+	return nodes
+}
+
+// selectNodes makes the given nodes the selected group
+func (canvas *CanvasPane) selectNodes(nodes []*Node) {
+	canvas.Select(nil)
+	for _, n := range nodes {
+		if n != nil {
+			canvas.group[n] = true
+		}
+	}
+}
+
+func (canvas *CanvasPane) Copy() {
+	canvas.clipboard = copyNodes(canvas.groupNodes())
+}
+
+// Paste the copied nodes with their top left corner at x, y
+func (canvas *CanvasPane) Paste(x, y int32) {
+	if len(canvas.clipboard) == 0 {
+		return
+	}
+	canvas.selectNodes(canvas.pasteNodes(canvas.clipboard, x, y))
+}
+
+// Duplicate copies the selected group and places the copy below it,
+// ready to be hooked up as another chain
+func (canvas *CanvasPane) Duplicate() {
+	nodes := canvas.groupNodes()
+	if len(nodes) == 0 {
+		return
+	}
+	var bounds sdl.Rect
+	for _, n := range nodes {
+		bounds = unionRect(bounds, n.GetPos())
+	}
+	copies := canvas.pasteNodes(copyNodes(nodes), bounds.X, bounds.Y + bounds.H + GRID_SIZE*2)
+	canvas.selectNodes(copies)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"log"
+	"math"
+	"sort"
+)
+
+// the level of a track is measured over windows this long, in seconds
+const SILENCE_WINDOW = 0.01
+
+// Region is a stretch of the timeline, in seconds
+type Region struct {
+	Start, End float64
+}
+
+func (r Region) Length() float64 {
+	return r.End - r.Start
+}
+
+// silenceResult is what analysing the tracks found. It only holds
+// for as long as the clips stay the same.
+type silenceResult struct {
+	keys map[*Node]string
+	tracks map[*Node][]Region
+	// where every track is silent at once
+	common []Region
+}
+
+// findSilence returns the parts of a track quieter than threshold dB
+// for at least minLength seconds. Everything after the last clip is
+// silent, up to infinity.
+func findSilence(clips []Clip, format Format, threshold, minLength float64) []Region {
+	var readers []*clipReader
+	var end int64
+	for _, c := range crossfades(clips) {
+		if r := openClip(c, format); r != nil {
+			readers = append(readers, r)
+			if e := r.frames.start + r.frames.length; e > end {
+				end = e
+			}
+		}
+	}
+	defer func() {
+		for _, r := range readers {
+			r.Release()
+		}
+	}()
+
+	var regions []Region
+	level := float32(dbToGain(threshold))
+	window := int64(math.Max(1, SILENCE_WINDOW * format.Rate))
+	// where the silence and the window being measured started
+	start, ws := int64(-1), int64(0)
+	peak := float32(0)
+	b := NewBlock(format)
+	for from := int64(0); from < end; from += BLOCK_SIZE {
+		frames := end - from
+		if frames > BLOCK_SIZE {
+			frames = BLOCK_SIZE
+		}
+		b.Clear(int(frames))
+		for _, r := range readers {
+			r.mix(b, from)
+		}
+		data := b.Samples()
+		for f := int64(0); f < frames; f++ {
+			for c := 0; c < b.Channels; c++ {
+				if s := data[f * int64(b.Channels) + int64(c)]; s > peak {
+					peak = s
+				} else if -s > peak {
+					peak = -s
+				}
+			}
+			frame := from + f + 1
+			if frame - ws < window && frame != end {
+				continue
+			}
+			if peak < level && start < 0 {
+				start = ws
+			} else if peak >= level && start >= 0 {
+				r := Region{float64(start) / format.Rate, float64(ws) / format.Rate}
+				if r.Length() >= minLength {
+					regions = append(regions, r)
+				}
+				start = -1
+			}
+			peak = 0
+			ws = frame
+		}
+	}
+	if start < 0 {
+		start = end
+	}
+	return append(regions, Region{float64(start) / format.Rate, math.Inf(1)})
+}
+
+// intersect returns where regions from both lists overlap
+func intersect(a, b []Region) []Region {
+	var out []Region
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		r := Region{math.Max(a[i].Start, b[j].Start), math.Min(a[i].End, b[j].End)}
+		if r.Start < r.End {
+			out = append(out, r)
+		}
+		if a[i].End < b[j].End {
+			i++
+		} else {
+			j++
+		}
+	}
+	return out
+}
+
+// snapshotTracks copies the clips of the inputs that have any, to be
+// analysed away from the UI
+func snapshotTracks(nodes []*Node) map[*Node]*track {
+	tracks := make(map[*Node]*track)
+	for _, n := range nodes {
+		if n.name == "input" && len(n.clips) > 0 {
+			tracks[n] = &track{n.clipsKey(), n.format, append([]Clip(nil), n.clips...)}
+		}
+	}
+	return tracks
+}
+
+// analyseSilence finds the silence on every track
+func analyseSilence(tracks map[*Node]*track, threshold, minLength float64) *silenceResult {
+	res := &silenceResult{keys: make(map[*Node]string), tracks: make(map[*Node][]Region)}
+	first := true
+	for n, t := range tracks {
+		regions := findSilence(t.clips, t.format, threshold, minLength)
+		res.keys[n] = t.key
+		res.tracks[n] = regions
+		if first {
+			res.common = regions
+			first = false
+		} else {
+			res.common = intersect(res.common, regions)
+		}
+	}
+	var common []Region
+	for _, r := range res.common {
+		if r.Length() >= minLength {
+			common = append(common, r)
+		}
+	}
+	res.common = common
+	return res
+}
+
+// current is true while none of the analysed tracks have changed
+func (res *silenceResult) current() bool {
+	for t, key := range res.keys {
+		if t.clipsKey() != key {
+			return false
+		}
+	}
+	return true
+}
+
+// cutTime removes a stretch of the timeline from a to b from the
+// clips, moving everything after it earlier
+func cutTime(clips []Clip, a, b float64) []Clip {
+	var out []Clip
+	for _, c := range clips {
+		switch {
+		case c.End() <= a:
+			out = append(out, c)
+		case c.Start >= b:
+			c.Start -= b - a
+			out = append(out, c)
+		default:
+			if c.Start < a {
+				left := c
+				left.TrimEnd(a)
+				left.FadeOut = 0
+				out = append(out, left)
+			}
+			if c.End() > b {
+				right := c
+				right.TrimStart(b)
+				right.Start = a
+				right.FadeIn = 0
+				out = append(out, right)
+			}
+		}
+	}
+	return out
+}
+
+// Cut removes a stretch of time from the envelope, moving the points
+// after it earlier
+func (env Envelope) Cut(a, b float64) Envelope {
+	var out Envelope
+	for _, p := range env {
+		if p.Time >= b {
+			p.Time -= b - a
+		} else if p.Time >= a {
+			continue
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// ShortenPauses cuts every pause where all tracks are silent for
+// longer than longer seconds down to short seconds, on every track,
+// envelope and chapter at once so they stay in sync. The middle of the
+// pause goes.
+func (canvas *CanvasPane) ShortenPauses(res *silenceResult, longer, short float64) {
+	var pauses []Region
+	for _, r := range res.common {
+		if r.Length() > longer && !math.IsInf(r.End, 1) {
+			pauses = append(pauses, r)
+		}
+	}
+	// from the end, so the earlier pauses stay where they were found
+	sort.Slice(pauses, func(i, j int) bool { return pauses[i].Start > pauses[j].Start })
+	cut := 0.0
+	for _, p := range pauses {
+		a, b := p.Start + short / 2, p.End - short / 2
+		for _, n := range canvas.nodes {
+			if n.name == "input" {
+				n.clips = cutTime(n.clips, a, b)
+			}
+			for _, param := range append(n.AllParams(), n.law) {
+				if env := param.Envelope(); len(env) > 0 {
+					param.SetEnvelope(env.Cut(a, b))
+				}
+			}
+		}
+		canvas.cutChapters(a, b)
+		cut += b - a
+	}
+	log.Printf("Shortened %d pauses, %.1f seconds cut\n", len(pauses), cut)
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"math"
+
+	"github.com/krig/Go-SDL2/sdl"
+	"github.com/krig/Go-SDL2/ttf"
+)
+
+const (
+	DOUBLECLICK_MS = 300
+	// fine adjustment divides mouse movement by this much
+	FINE_ADJUST = 10.0
+	WHEEL_STEP = 0.02
+)
+
+// WheelLover is implemented by widgets that want mouse wheel events.
+// The InputStack passes them on to any lover that implements it.
+type WheelLover interface {
+	OnMouseWheelEvent(event *sdl.MouseWheelEvent) bool
+}
+
+// ParamControl holds what sliders and knobs have in common: a bound
+// parameter, a name and a value readout, dragging with shift for fine
+// adjustment, double-click to reset and mouse wheel stepping.
+type ParamControl struct {
+	Widget
+	param *Param
+	rend *sdl.Renderer
+	name Label
+	readout Label
+	Color sdl.Color
+	dragging bool
+	hover bool
+	changed bool
+	lastclick uint32
+	// pixels of mouse travel for the whole range
+	travel float64
+	changehandler func(p *Param)
+}
+
+type Slider struct {
+	ParamControl
+}
+
+type Knob struct {
+	ParamControl
+}
+
+func (ctl *ParamControl) init(rend *sdl.Renderer, space sdl.Rect, param *Param, font *ttf.Font) {
+	ctl.Pos = space
+	ctl.param = param
+	ctl.rend = rend
+	ctl.Color = hexcolor(0x694ae9)
+	ctl.name.Init(rend, space, param.Name, font, hexcolor(0x303030))
+	ctl.readout.Init(rend, space, param.Format(), font, hexcolor(0x303030))
+}
+
+// OnChange sets a handler, called when the user is done changing the value
+func (ctl *ParamControl) OnChange(handler func(p *Param)) {
+	ctl.changehandler = handler
+}
+
+func (ctl *ParamControl) Destroy() {
+	ctl.name.Destroy()
+	ctl.readout.Destroy()
+}
+
+func (ctl *ParamControl) updateReadout() {
+	text := ctl.param.Format()
+	if text != ctl.readout.Text {
+		ctl.readout.Text = text
+		ctl.readout.Update(ctl.rend)
+	}
+}
+
+func (ctl *ParamControl) commit() {
+	ctl.updateReadout()
+	if ctl.changed && ctl.changehandler != nil {
+		ctl.changehandler(ctl.param)
+	}
+	ctl.changed = false
+}
+
+func (ctl *ParamControl) nudge(delta float64) {
+	if (sdl.GetModState() & sdl.KMOD_SHIFT) != 0 {
+		delta /= FINE_ADJUST
+	}
+	ctl.param.SetNorm(ctl.param.Norm() + delta)
+	ctl.changed = true
+	ctl.updateReadout()
+}
+
+func (ctl *ParamControl) OnMouseButtonEvent(event *sdl.MouseButtonEvent) bool {
+	if event.Button != sdl.BUTTON_LEFT {
+		return true
+	}
+	if event.State == sdl.PRESSED && ctl.Pos.Contains(event.X, event.Y) {
+		now := sdl.GetTicks()
+		if ctl.lastclick != 0 && now - ctl.lastclick < DOUBLECLICK_MS {
+			ctl.param.Reset()
+			ctl.changed = true
+			ctl.commit()
+			ctl.lastclick = 0
+			return false
+		}
+		ctl.lastclick = now
+		ctl.dragging = true
+		return false
+	}
+	if event.State == sdl.RELEASED && ctl.dragging {
+		ctl.dragging = false
+		ctl.commit()
+		return false
+	}
+	return true
+}
+
+func (ctl *ParamControl) OnMouseWheelEvent(event *sdl.MouseWheelEvent) bool {
+	if !ctl.hover {
+		return true
+	}
+	ctl.nudge(float64(event.Y) * WHEEL_STEP)
+	ctl.commit()
+	return false
+}
+
+func (slider *Slider) Init(rend *sdl.Renderer, space sdl.Rect, param *Param, font *ttf.Font) {
+	slider.init(rend, space, param, font)
+}
+
+func (slider *Slider) OnMouseMotionEvent(event *sdl.MouseMotionEvent) bool {
+	slider.hover = slider.Pos.Contains(event.X, event.Y)
+	if slider.dragging {
+		slider.nudge(float64(event.XRel) / float64(slider.Pos.W))
+	}
+	return true
+}
+
+// Draw a slider: the name on the left, a track with a handle,
+// and the value readout on the right.
+func (slider *Slider) Draw(rend *sdl.Renderer) {
+	third := slider.Pos.W / 3
+	slider.name.Pos = sdl.Rect{slider.Pos.X, slider.Pos.Y, third, slider.Pos.H}
+	slider.readout.Pos = sdl.Rect{slider.Pos.X + third*2, slider.Pos.Y, third, slider.Pos.H}
+	slider.name.Draw(rend)
+	slider.readout.Draw(rend)
+
+	track := sdl.Rect{slider.Pos.X + third, slider.Pos.Y + slider.Pos.H/2 - 2, third, 4}
+	rend.SetDrawColor(darken(slider.Color, 60))
+	rend.FillRect(&track)
+
+	fill := track
+	fill.W = int32(float64(track.W) * slider.param.Norm())
+	clr := slider.Color
+	if slider.hover || slider.dragging {
+		clr = lighten(clr, 30)
+	}
+	rend.SetDrawColor(clr)
+	rend.FillRect(&fill)
+	handle := sdl.Rect{track.X + fill.W - 3, slider.Pos.Y + 2, 6, slider.Pos.H - 4}
+	rend.FillRect(&handle)
+}
+
+func (knob *Knob) Init(rend *sdl.Renderer, space sdl.Rect, param *Param, font *ttf.Font) {
+	knob.init(rend, space, param, font)
+	knob.travel = 200
+}
+
+func (knob *Knob) OnMouseMotionEvent(event *sdl.MouseMotionEvent) bool {
+	knob.hover = knob.Pos.Contains(event.X, event.Y)
+	if knob.dragging {
+		knob.nudge(-float64(event.YRel) / knob.travel)
+	}
+	return true
+}
+
+// Draw a knob: a dial sweeping 270 degrees with the name above
+// and the value readout below.
+func (knob *Knob) Draw(rend *sdl.Renderer) {
+	lh := knob.name.texheight
+	knob.name.Pos = sdl.Rect{knob.Pos.X, knob.Pos.Y, knob.Pos.W, lh}
+	knob.readout.Pos = sdl.Rect{knob.Pos.X, knob.Pos.Y + knob.Pos.H - lh, knob.Pos.W, lh}
+	knob.name.Draw(rend)
+	knob.readout.Draw(rend)
+
+	cx := float64(knob.Pos.X + knob.Pos.W/2)
+	cy := float64(knob.Pos.Y + knob.Pos.H/2)
+	r := math.Min(float64(knob.Pos.W), float64(knob.Pos.H - lh*2)) / 2 - 2
+	start := math.Pi * 0.75
+	sweep := math.Pi * 1.5
+
+	arc := func(from, to float64, clr sdl.Color) {
+		rend.SetDrawColor(clr)
+		steps := int(math.Max(2, (to - from) * 8))
+		px, py := cx + r * math.Cos(from), cy + r * math.Sin(from)
+		for i := 1; i <= steps; i++ {
+			a := from + (to - from) * float64(i) / float64(steps)
+			x, y := cx + r * math.Cos(a), cy + r * math.Sin(a)
+			rend.DrawLine(int32(px), int32(py), int32(x), int32(y))
+			px, py = x, y
+		}
+	}
+	clr := knob.Color
+	if knob.hover || knob.dragging {
+		clr = lighten(clr, 30)
+	}
+	pos := start + sweep * knob.param.Norm()
+	arc(start, start + sweep, darken(knob.Color, 60))
+	arc(start, pos, clr)
+	rend.SetDrawColor(clr)
+	rend.DrawLine(int32(cx), int32(cy), int32(cx + r * math.Cos(pos)), int32(cy + r * math.Sin(pos)))
+}
+
+// ParamPanel shows a control for each parameter of a node
+type ParamPanel struct {
+	Widget
+	node *Node
+	title Label
+	controls []ParamWidget
+	rsc *Resources
+	changehandler func(node *Node, p *Param)
+}
+
+// ParamWidget is a slider or a knob
+type ParamWidget interface {
+	Visual
+	MouseLover
+	WheelLover
+	OnChange(handler func(p *Param))
+}
+
+const (
+	PANEL_WIDTH = int32(200)
+	SLIDER_HEIGHT = int32(20)
+	KNOB_SIZE = int32(64)
+)
+
+func (panel *ParamPanel) Init(rsc *Resources) {
+	panel.rsc = rsc
+	panel.title.Init(rsc.renderer, panel.Pos, "", rsc.TitleFont, rsc.TitleColor)
+}
+
+func (panel *ParamPanel) OnChange(handler func(node *Node, p *Param)) {
+	panel.changehandler = handler
+}
+
+// Bind the panel to the given node, or hide it if node is nil
+func (panel *ParamPanel) Bind(node *Node) {
+	if node == panel.node {
+		return
+	}
+	for _, c := range panel.controls {
+		c.Destroy()
+	}
+	panel.controls = nil
+	panel.node = node
+	if node == nil {
+		return
+	}
+	panel.title.Text = node.label.Text
+	panel.title.Update(panel.rsc.renderer)
+
+	// dB parameters get faders, the rest share a row of knobs
+	y := panel.Pos.Y + SLIDER_HEIGHT
+	x := panel.Pos.X
+	knobs := false
+	for _, p := range node.AllParams() {
+		if p.Unit == UNIT_DB {
+			continue
+		}
+		k := &Knob{}
+		k.Init(panel.rsc.renderer, sdl.Rect{x, y, KNOB_SIZE, KNOB_SIZE}, p, panel.rsc.TitleFont)
+		panel.add(k)
+		x += KNOB_SIZE
+		if x + KNOB_SIZE > panel.Pos.X + PANEL_WIDTH {
+			x = panel.Pos.X
+			y += KNOB_SIZE
+		}
+		knobs = true
+	}
+	if knobs && x != panel.Pos.X {
+		y += KNOB_SIZE
+	}
+	for _, p := range node.AllParams() {
+		if p.Unit != UNIT_DB {
+			continue
+		}
+		s := &Slider{}
+		s.Init(panel.rsc.renderer, sdl.Rect{panel.Pos.X + 4, y, PANEL_WIDTH - 8, SLIDER_HEIGHT}, p, panel.rsc.TitleFont)
+		panel.add(s)
+		y += SLIDER_HEIGHT
+	}
+	panel.Pos.W = PANEL_WIDTH
+	panel.Pos.H = y - panel.Pos.Y + 4
+}
+
+func (panel *ParamPanel) add(ctl ParamWidget) {
+	ctl.OnChange(func(p *Param) {
+		if panel.changehandler != nil {
+			panel.changehandler(panel.node, p)
+		}
+	})
+	panel.controls = append(panel.controls, ctl)
+}
+
+// UpdateLayout docks the panel in the bottom right corner of space
+func (panel *ParamPanel) UpdateLayout(space sdl.Rect) {
+	x := space.X + space.W - panel.Pos.W - 4
+	y := space.Y + space.H - panel.Pos.H - 4
+	dx, dy := x - panel.Pos.X, y - panel.Pos.Y
+	panel.Pos.X, panel.Pos.Y = x, y
+	for _, c := range panel.controls {
+		pos := c.GetPos()
+		pos.X += dx
+		pos.Y += dy
+		c.SetPos(pos)
+	}
+}
+
+func (panel *ParamPanel) Draw(rend *sdl.Renderer) {
+	if panel.node == nil || len(panel.controls) == 0 {
+		return
+	}
+	rend.SetDrawColor(panel.rsc.TitleBarColor)
+	rend.FillRect(&panel.Pos)
+	rend.SetDrawColor(darken(panel.rsc.TitleBarColor, 9))
+	rend.DrawRect(&panel.Pos)
+	panel.title.Pos = sdl.Rect{panel.Pos.X, panel.Pos.Y, panel.Pos.W, SLIDER_HEIGHT}
+	panel.title.Draw(rend)
+	for _, c := range panel.controls {
+		c.Draw(rend)
+	}
+}
+
+func (panel *ParamPanel) Destroy() {
+	panel.Bind(nil)
+	panel.title.Destroy()
+}
+
+// Contains is true if the panel is showing and covers the point
+func (panel *ParamPanel) Contains(x, y int32) bool {
+	return panel.node != nil && len(panel.controls) > 0 && panel.Pos.Contains(x, y)
+}
+
+func (panel *ParamPanel) OnMouseMotionEvent(event *sdl.MouseMotionEvent) bool {
+	for _, c := range panel.controls {
+		c.OnMouseMotionEvent(event)
+	}
+	return true
+}
+
+func (panel *ParamPanel) OnMouseButtonEvent(event *sdl.MouseButtonEvent) bool {
+	for _, c := range panel.controls {
+		if !c.OnMouseButtonEvent(event) {
+			return false
+		}
+	}
+	return true
+}
+
+func (panel *ParamPanel) OnMouseWheelEvent(event *sdl.MouseWheelEvent) bool {
+	for _, c := range panel.controls {
+		if !c.OnMouseWheelEvent(event) {
+			return false
+		}
+	}
+	return true
+}
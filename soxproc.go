@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/krig/go-sox"
+)
+
+const (
+	// blocks of silence fed to a SoX effect ahead of the audio, so there
+	// is always output waiting when a block is pulled through it
+	SOX_LATENCY = 8
+	// how long a block of a render waits for SoX before going out
+	// with silence where the effect's output should be
+	SOX_WAIT_MS = 50
+)
+
+// soxProcessor runs a SoX effect in a chain of its own, streaming
+// blocks to it and back through a pair of named pipes. The audio
+// comes out SOX_LATENCY blocks late, which the engine makes up for on
+// everything mixed with it. Output that is late goes out as silence
+// and is dropped when it turns up, so the effect never stalls
+// playback and stays in step; renders wait a little for it first.
+// Effects that change the length of the
+// audio can't stay in step: what they add is dropped, and what they
+// leave out is silence.
+type soxProcessor struct {
+	dir string
+	// blocks on their way to SoX, and the buffers free to send them in
+	in chan []float32
+	free chan []float32
+	out *os.File
+	channels int
+	// what has come back from SoX, filled by read as it arrives
+	lock sync.Mutex
+	fifo []float32
+	// samples that went out as silence, dropped when they arrive
+	owed int
+	longer bool
+	arrived chan bool
+	// set when SoX has stopped sending anything back
+	done int32
+	failed bool
+	// the audio sent, held back as long as SoX holds it, to pass
+	// through in its place if the effect fails
+	lag lagLine
+	// whether a block waits for SoX, which only renders can afford
+	waits bool
+}
+
+func newSoxProcessor(effect string, args []string, format Format) Processor {
+	if sox.FindEffect(effect) == nil {
+		log.Println("Unknown effect:", effect)
+		return nil
+	}
+	dir, err := os.MkdirTemp("", "podcast-studio-")
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	inPath := filepath.Join(dir, "in.wav")
+	outPath := filepath.Join(dir, "out.f32")
+	for _, path := range []string{inPath, outPath} {
+		if err := syscall.Mkfifo(path, 0600); err != nil {
+			log.Println(err)
+			os.RemoveAll(dir)
+			return nil
+		}
+	}
+	// opening for reading and writing doesn't wait for the other end
+	out, err := os.OpenFile(outPath, os.O_RDWR, 0)
+	if err != nil {
+		log.Println(err)
+		os.RemoveAll(dir)
+		return nil
+	}
+	p := &soxProcessor{
+		dir: dir,
+		in: make(chan []float32, SOX_LATENCY * 2),
+		free: make(chan []float32, SOX_LATENCY * 2),
+		out: out,
+		channels: format.Channels,
+		fifo: make([]float32, 0, SOX_LATENCY * 2 * BLOCK_SIZE * format.Channels),
+		arrived: make(chan bool, 1),
+		lag: newLagLine(SOX_LATENCY * BLOCK_SIZE * format.Channels),
+	}
+	for i := 0; i < SOX_LATENCY * 2; i++ {
+		p.free <- make([]float32, 0, BLOCK_SIZE * format.Channels)
+	}
+	for i := 0; i < SOX_LATENCY; i++ {
+		silence := <-p.free
+		p.in <- silence[:cap(silence)]
+	}
+	go p.feed(inPath, format)
+	go p.read()
+	go p.flow(effect, args, inPath, outPath, format)
+	return p
+}
+
+// feed writes the blocks sent to the processor into the input pipe,
+// as a WAV file that never ends
+func (p *soxProcessor) feed(path string, format Format) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer f.Close()
+	writeWavHeader(f, format)
+	for data := range p.in {
+		if err := binary.Write(f, binary.LittleEndian, data); err != nil {
+			return
+		}
+		p.free <- data[:0]
+	}
+}
+
+// read takes what comes back from SoX into the fifo as it arrives,
+// until the pipe is closed
+func (p *soxProcessor) read() {
+	defer atomic.StoreInt32(&p.done, 1)
+	raw := make([]byte, BLOCK_SIZE * p.channels * 4)
+	have := 0
+	most := cap(p.fifo)
+	for {
+		n, err := p.out.Read(raw[have:])
+		have += n
+		count := have / 4
+		p.lock.Lock()
+		skip := count
+		if p.owed < skip {
+			skip = p.owed
+		}
+		p.owed -= skip
+		for i := skip; i < count; i++ {
+			p.fifo = append(p.fifo, math.Float32frombits(binary.LittleEndian.Uint32(raw[i * 4:])))
+		}
+		if over := len(p.fifo) - most; over > 0 {
+			// whole frames, so the channels stay in order
+			over = (over + p.channels - 1) / p.channels * p.channels
+			p.fifo = p.fifo[:copy(p.fifo, p.fifo[over:])]
+			if !p.longer {
+				log.Println("SoX effect makes the audio longer, the extra is dropped")
+				p.longer = true
+			}
+		}
+		p.lock.Unlock()
+		have = copy(raw, raw[count * 4:have])
+		select {
+		case p.arrived <- true:
+		default:
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// writeWavHeader writes the header of a 32 bit float WAV file of
+// unknown length
+func writeWavHeader(w io.Writer, format Format) {
+	size := uint32(math.MaxUint32)
+	bytes := uint32(format.Rate) * uint32(format.Channels) * 4
+	binary.Write(w, binary.LittleEndian, []byte("RIFF"))
+	binary.Write(w, binary.LittleEndian, size)
+	binary.Write(w, binary.LittleEndian, []byte("WAVEfmt "))
+	binary.Write(w, binary.LittleEndian, []uint32{16})
+	binary.Write(w, binary.LittleEndian, []uint16{3, uint16(format.Channels)})
+	binary.Write(w, binary.LittleEndian, []uint32{uint32(format.Rate), bytes})
+	binary.Write(w, binary.LittleEndian, []uint16{uint16(format.Channels) * 4, 32})
+	binary.Write(w, binary.LittleEndian, []byte("data"))
+	binary.Write(w, binary.LittleEndian, size - 36)
+}
+
+// flow runs the SoX chain until the input pipe is closed
+func (p *soxProcessor) flow(effect string, args []string, inPath, outPath string, format Format) {
+	defer p.out.Close()
+	in := sox.OpenRead(inPath)
+	if in == nil {
+		log.Println("Failed to open pipe to SoX effect:", effect)
+		return
+	}
+	defer in.Release()
+	signal := sox.NewSignalInfo(format.Rate, uint(format.Channels), 32, 0, nil)
+	encoding := sox.NewEncodingInfo(sox.ENCODING_FLOAT, 32, 0, false)
+	out := sox.OpenWrite(outPath, signal, encoding, "f32")
+	if out == nil {
+		log.Println("Failed to open pipe from SoX effect:", effect)
+		return
+	}
+	defer out.Release()
+
+	chain := sox.CreateEffectsChain(in.Encoding(), out.Encoding())
+	defer chain.Release()
+	addSoxEffect(chain, "input", in.Signal(), in)
+	opts := make([]interface{}, len(args))
+	for i, a := range args {
+		opts[i] = a
+	}
+	addSoxEffect(chain, effect, in.Signal(), opts...)
+	addSoxEffect(chain, "output", in.Signal(), out)
+	chain.Flow()
+}
+
+func addSoxEffect(chain *sox.EffectsChain, name string, signal *sox.SignalInfo, opts ...interface{}) {
+	e := sox.CreateEffect(sox.FindEffect(name))
+	e.Options(opts...)
+	chain.Add(e, signal, signal)
+	e.Release()
+}
+
+// Process sends the block to SoX and replaces it with what comes back.
+// If the effect stops working, the block passes through untouched, as
+// late as the effect would have made it.
+func (p *soxProcessor) Process(block *Block) {
+	samples := block.Samples()
+	if p.failed {
+		p.lag.run(samples)
+		return
+	}
+	var buf []float32
+	select {
+	case buf = <-p.free:
+	default:
+		// SoX has stopped taking audio in, like an effect that reads
+		// everything before it lets anything out
+		log.Println("SoX effect stopped keeping up, passing the audio through")
+		p.failed = true
+		p.lag.run(samples)
+		return
+	}
+	p.in <- append(buf, samples...)
+	p.lag.run(samples)
+	if p.waits {
+		p.wait(len(samples))
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if len(p.fifo) < len(samples) && atomic.LoadInt32(&p.done) != 0 {
+		log.Println("SoX effect stopped, passing the audio through")
+		p.failed = true
+		return
+	}
+	n := copy(samples, p.fifo)
+	p.fifo = p.fifo[:copy(p.fifo, p.fifo[n:])]
+	for i := n; i < len(samples); i++ {
+		samples[i] = 0
+	}
+	p.owed += len(samples) - n
+}
+
+// wait waits up to SOX_WAIT_MS for n samples to have come back
+func (p *soxProcessor) wait(n int) {
+	deadline := time.Now().Add(SOX_WAIT_MS * time.Millisecond)
+	for {
+		p.lock.Lock()
+		have := len(p.fifo)
+		p.lock.Unlock()
+		if have >= n || atomic.LoadInt32(&p.done) != 0 {
+			return
+		}
+		select {
+		case <-p.arrived:
+		case <-time.After(time.Until(deadline)):
+			return
+		}
+	}
+}
+
+// Latency returns the silence SoX was fed ahead of the audio
+func (p *soxProcessor) Latency() int {
+	return SOX_LATENCY * BLOCK_SIZE
+}
+
+func (p *soxProcessor) Close() {
+	close(p.in)
+	os.RemoveAll(p.dir)
+}
@@ -4,7 +4,9 @@ package main
 import (
 	"log"
 	"math"
-	"path/filepath"
+	"os"
+	"sort"
+	"strings"
 
 	"github.com/krig/Go-SDL2/sdl"
 	"github.com/krig/Go-SDL2/ttf"
@@ -14,6 +16,11 @@ import (
 
 const (
 	TOPBAR_HEIGHT = int32(32)
+
+	// where imported files are placed
+	GRID_MARGIN = int32(16)
+	GRID_COLUMN = int32(160)
+	GRID_ROW = int32(64)
 )
 
 type Resources struct {
@@ -36,15 +43,43 @@ type Node struct {
 	Widget
 	label Label
 	color sdl.Color
+	fade ColorAnimation
+	linkfade ColorAnimation
 	dragging bool
+	// easing into a grid position after a drag
+	settling bool
 	curr FloatPos
 	goal FloatPos
 	menu PopupMenu
 
+	// unique on the canvas, copies get a new one
+	id int
 	name string
 	args []string
+	effect string
+	params []*Param
+	// where the node sits between left and right, and the pan law
+	// of inputs and mixers
+	pan, law *Param
+	// switched from the UI while playing
+	bypass, mute, solo Toggle
+	// marks what a ducker listens to instead of turning down
+	key Toggle
+	// plays what a de-esser hears instead of its output
+	listen Toggle
+	// shows what a declicker or declipper finds in the track view
+	markers Toggle
+	// the clips on an input, and the format of its first file
+	clips []Clip
+	format Format
+	// how sure the last alignment to the reference was, 0 if never
+	aligned float64
+	// the noise a denoise effect takes out
+	profile *NoiseProfile
 
 	next *Node
+	// Pos is in canvas coordinates, the camera maps it to the screen
+	cam *Camera
 }
 
 // Color scheme:
@@ -55,32 +90,77 @@ type Node struct {
 // blue: 694ae9
 
 
-func (node *Node) DrawLink(rend *sdl.Renderer) {
-	if node.next != nil {
-		rend.SetDrawColor(hexcolor(0x15f0e1))
-		p0 := node.Pos
-		p1 := node.next.Pos
-		rend.DrawLine(p0.X + p0.W/2, p0.Y + p0.H/2, p1.X + p1.W/2, p1.Y + p1.H/2)
+// Param returns the named parameter of the node, or nil
+func (node *Node) Param(name string) *Param {
+	for _, p := range node.params {
+		if p.Name == name {
+			return p
+		}
+	}
+	for _, p := range []*Param{node.pan, node.law} {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// AllParams returns the parameters shown for the node, with the pan
+// of inputs and of anything going into a mixer
+func (node *Node) AllParams() []*Param {
+	params := append([]*Param(nil), node.params...)
+	if node.name == "input" || (node.next != nil && node.next.mixes()) {
+		params = append(params, node.pan)
 	}
+	return params
+}
+
+// EffectArgs returns the SoX arguments for an effect node
+func (node *Node) EffectArgs() []string {
+	def, ok := effectDefs[node.effect]
+	if !ok || def.Args == nil {
+		return node.args
+	}
+	return def.Args(node.params)
+}
+
+// MoveTo places the node at the given position, snapped to the grid
+func (node *Node) MoveTo(x, y float64) {
+	node.goal = FloatPos{snap(x), snap(y)}
+	node.curr = node.goal
+	node.Pos.X = int32(node.curr.X)
+	node.Pos.Y = int32(node.curr.Y)
+	node.label.Pos = node.Pos
+}
+
+func (node *Node) StartDrag() {
+	node.dragging = true
+	node.settling = false
+	node.goal = node.curr
 }
 
 func (node *Node) Draw(rend *sdl.Renderer) {
-	if node.dragging {
+	if node.dragging || node.settling {
 		node.curr.X += (node.goal.X - node.curr.X) * (15.0 / 30.0)
 		node.curr.Y += (node.goal.Y - node.curr.Y) * (15.0 / 30.0)
+		if node.settling && math.Abs(node.goal.X - node.curr.X) < 0.5 && math.Abs(node.goal.Y - node.curr.Y) < 0.5 {
+			node.curr = node.goal
+			node.settling = false
+		}
 		node.Pos.X = int32(node.curr.X)
 		node.Pos.Y = int32(node.curr.Y)
-		node.label.Pos = node.Pos
 	}
 
-	clr := node.color
+	pos := node.cam.Rect(node.Pos)
+	clr := node.fade.Get()
 	if !node.dragging {
 		rend.SetDrawColor(clr)
-		rend.FillRect(&node.Pos)
+		rend.FillRect(&pos)
 	}
 	rend.SetDrawColor(lighten(clr, 19))
-	rend.DrawRect(&node.Pos)
-	node.label.Draw(rend)
+	rend.DrawRect(&pos)
+	node.label.Pos = pos
+	node.label.DrawScaled(rend, node.cam.Zoom)
 
 	if node.menu.Visible {
 		node.menu.Draw(rend)
@@ -89,11 +169,8 @@ func (node *Node) Draw(rend *sdl.Renderer) {
 
 func (node *Node) OnMouseMotionEvent(event *sdl.MouseMotionEvent) bool {
 	if node.dragging {
-		node.goal.X += float64(event.XRel)
-		node.goal.Y += float64(event.YRel)
-
-		node.goal.X = math.Max(node.goal.X, 0.0)
-		node.goal.Y = math.Max(node.goal.Y, float64(TOPBAR_HEIGHT))
+		node.goal.X += float64(event.XRel) / node.cam.Zoom
+		node.goal.Y += float64(event.YRel) / node.cam.Zoom
 	}
 	if node.menu.Visible {
 		node.menu.OnMouseMotionEvent(event)
@@ -108,12 +185,10 @@ func (node *Node) OnMouseButtonEvent(event *sdl.MouseButtonEvent) bool {
 	if node.menu.Visible {
 		node.menu.OnMouseButtonEvent(event)
 	}
-	if node.Pos.Contains(event.X, event.Y) {
+	pos := node.cam.Rect(node.Pos)
+	if pos.Contains(event.X, event.Y) {
 		if lpress {
-			node.dragging = true
-			node.goal.X = float64(node.Pos.X)
-			node.goal.Y = float64(node.Pos.Y)
-			node.curr = node.goal
+			node.StartDrag()
 		}
 		if rpress && !node.menu.Visible {
 			node.menu.Show(event.X, event.Y)
@@ -123,36 +198,15 @@ func (node *Node) OnMouseButtonEvent(event *sdl.MouseButtonEvent) bool {
 	}
 	if node.dragging && event.State == sdl.RELEASED {
 		node.dragging = false
+		node.goal.X = snap(node.goal.X)
+		node.goal.Y = snap(node.goal.Y)
+		node.settling = true
 	}
 	return true
 }
 
 
 
-// TODO
-type SoxChain struct {
-	chain *sox.EffectsChain
-	in, out *sox.Format
-	interrupt bool
-	finished bool
-}
-
-func (chain *SoxChain) Release() {
-	chain.chain.Release()
-	chain.in.Release()
-	chain.out.Release()
-}
-
-func (chain *SoxChain) Flow() {
-	chain.chain.FlowCallback(func(all_done bool) int {
-		if chain.interrupt {
-			return 1
-		}
-		return 0
-	})
-	chain.finished = true
-}
-
 type TopBar struct {
 	HorizontalLayout
 	BackgroundColor sdl.Color
@@ -164,12 +218,53 @@ type CanvasPane struct {
 
 	rsc *Resources
 
-	tracks []string
-
 	nodes []*Node
 	new_link *int
 
-	playing *SoxChain
+	selected *Node
+	// nodes selected together, moved and copied as a group
+	group map[*Node]bool
+	banding bool
+	band_x, band_y int32
+	clipboard []NodeSpec
+	chains []ChainPreset
+	lastid int
+	// the selected chain, and nodes that never reach an output
+	highlight map[*Node]bool
+	dangling map[*Node]bool
+	offsets map[*Node]int32
+	warning Label
+	badges map[string]*Label
+	// the format on the link out of each node
+	formats map[*Node]Format
+	formatKey string
+	formatLabels map[string]*Label
+
+	panel ParamPanel
+	cam Camera
+	minimap Minimap
+	panning bool
+
+	playing *Engine
+	// the links the engine is playing
+	topology string
+	// the project file last saved or opened
+	project string
+	// the input others are aligned to, and where alignments and drift
+	// measurements arrive
+	reference *Node
+	alignments chan alignment
+	drifts chan drift
+	// markers on the timeline, and how the mix is exported
+	chapters []Chapter
+	export Export
+
+	// opens a file chooser, set by the screen
+	openFile func(callback func(filename string))
+	// asks for a line of text, set by the screen
+	askText func(title, text string, callback func(text string))
+	// asks for several, set by the screen
+	askForm func(title string, names, values []string, callback func(values []string))
 }
 
 type ListWindow struct {
@@ -187,14 +282,19 @@ type Screen struct {
 	Stop *Button
 
 	rsc *Resources
+	Views *Views
 	Canvas *CanvasPane
+	Tracks *TrackPane
+	Files *FileBrowser
+	Text *TextDialog
+	Form *FormDialog
 
 	stack InputStack
+	// when set, gets all input instead of the stack
+	modal Modal
 
 	framerate *gfx.FPSmanager
-
-	//Tracks *TrackPane
-	//Current *Pane
+	lastframe uint32
 }
 
 type InputStack struct {
@@ -240,6 +340,24 @@ func (stack *InputStack) OnMouseButtonEvent(event *sdl.MouseButtonEvent) bool {
 	return true
 }
 
+func (stack *InputStack) OnKeyboardEvent(event *sdl.KeyboardEvent) bool {
+	for _, l := range stack.lovers {
+		if k, ok := l.(KeyLover); ok && !k.OnKeyboardEvent(event) {
+			return false
+		}
+	}
+	return true
+}
+
+func (stack *InputStack) OnMouseWheelEvent(event *sdl.MouseWheelEvent) bool {
+	for _, l := range stack.lovers {
+		if w, ok := l.(WheelLover); ok && !w.OnMouseWheelEvent(event) {
+			return false
+		}
+	}
+	return true
+}
+
 func (tb *TopBar) Draw(rend *sdl.Renderer) {
 	rend.SetDrawColor(tb.BackgroundColor)
 	rend.FillRect(&tb.Pos)
@@ -262,8 +380,20 @@ func (tb *TopBar) Draw(rend *sdl.Renderer) {
 func (canvas *CanvasPane) Init(rsc *Resources, space sdl.Rect, tracks []string) {
 	canvas.rsc = rsc
 	canvas.Pos = space
-	canvas.tracks = tracks
-	canvas.menu.Init(rsc.renderer, space, []string{"+input", "+output", "+effect"}, rsc.TitleFont)
+	canvas.cam.Init(space)
+	canvas.minimap.Init(canvas)
+	canvas.menu.Init(rsc.renderer, space, []string{"+input", "+output", "+effect", "+mixer", "+ducker", "+automix", "+router", "save chain...", "render...", "export...", "open project...", "save project..."}, rsc.TitleFont)
+	canvas.panel.Init(rsc)
+	canvas.panel.OnChange(canvas.ParamChanged)
+	canvas.warning.Init(rsc.renderer, space, "not connected to an output", rsc.TitleFont, hexcolor(0xff3015))
+	canvas.formatLabels = make(map[string]*Label)
+	canvas.badges = make(map[string]*Label)
+	canvas.alignments = make(chan alignment, 8)
+	canvas.drifts = make(chan drift, 8)
+	for _, b := range []string{"B", "M", "S", "K", "R", "L"} {
+		canvas.badges[b] = &Label{}
+		canvas.badges[b].Init(rsc.renderer, space, b, rsc.TitleFont, hexcolor(0x303030))
+	}
 
 	canvas.menu.OnClick(func(entry *MenuEntry) {
 		log.Println("Clicked: " + entry.Text)
@@ -273,117 +403,356 @@ func (canvas *CanvasPane) Init(rsc *Resources, space sdl.Rect, tracks []string)
 			canvas.NewOutput()
 		} else if entry.Text == "+effect" {
 			canvas.NewEffect()
+		} else if entry.Text == "+mixer" {
+			canvas.NewMixer()
+		} else if entry.Text == "+router" {
+			canvas.NewRouter()
+		} else if entry.Text == "+ducker" {
+			x, y := canvas.menuPos()
+			canvas.makeNode("ducker", x, y)
+		} else if entry.Text == "+automix" {
+			x, y := canvas.menuPos()
+			canvas.makeNode("automix", x, y)
+		} else if entry.Text == "save chain..." {
+			canvas.SaveChain()
+		} else if entry.Text == "render..." {
+			canvas.askText("render to file", "mix.wav", canvas.Render)
+		} else if entry.Text == "export..." {
+			canvas.askForm("export", exportFields, canvas.export.values(), func(values []string) {
+				canvas.export = exportFrom(values)
+				canvas.Export(canvas.export)
+			})
+		} else if entry.Text == "open project..." {
+			canvas.askText("open project", canvas.projectName(), canvas.LoadProject)
+		} else if entry.Text == "save project..." {
+			canvas.askText("save project as", canvas.projectName(), canvas.SaveProject)
 		}
 	})
+	canvas.chains = loadChainPresets()
+	canvas.export = defaultExport()
+
+	canvas.AddFiles(tracks)
+	canvas.FitToContent()
 }
 
-func (canvas *CanvasPane) NewInput() {
-	n := &Node{}
-	n.Pos = canvas.menu.Pos
-	n.Pos.W = 64
-	n.Pos.H = 48
-	n.color = hexcolor(0x5be33b)
-	n.name = "input"
-	n.label.Init(canvas.rsc.renderer, n.Pos, n.name, canvas.rsc.TitleFont, hexcolor(0x303030))
-
-	openFileDialog(func(filename string) {
-		n.args = make([]string, 1, 1)
-		n.args[0] = filename
-		n.label.Text = filepath.Base(filename)
-		n.label.Update(canvas.rsc.renderer)
-		if n.Pos.W < n.label.texwidth + 8 {
-			n.Pos.W = n.label.texwidth + 8
-		}
-		if n.Pos.H < n.label.texheight + 8 {
-			n.Pos.H = n.label.texheight + 8
-		}
-		n.label.Pos = n.Pos
-	})
+// SetLabel changes the text on the node, growing it to fit
+func (node *Node) SetLabel(rend *sdl.Renderer, text string) {
+	node.label.Text = text
+	node.label.Update(rend)
+	if node.Pos.W < node.label.texwidth + 8 {
+		node.Pos.W = node.label.texwidth + 8
+	}
+	if node.Pos.H < node.label.texheight + 8 {
+		node.Pos.H = node.label.texheight + 8
+	}
+	node.label.Pos = node.Pos
+}
 
-	//n.menu.Init(canvas.rsc.renderer,
-	//	n.Pos,
-	//	[]string{"Open File..."},
-	//	canvas.rsc.TitleFont)
+func (canvas *CanvasPane) newNode(name, text string, color sdl.Color, x, y int32) *Node {
+	n := &Node{}
+	canvas.lastid++
+	n.id = canvas.lastid
+	n.Pos = sdl.Rect{x, y, 64, 48}
+	n.color = color
+	n.name = name
+	n.pan = NewParam(panSpec)
+	n.law = NewParam(lawSpec)
+	n.label.Init(canvas.rsc.renderer, n.Pos, text, canvas.rsc.TitleFont, hexcolor(0x303030))
+	n.fade.Init(color, color, FADE_SPEED)
+	n.linkfade.Init(hexcolor(chainPalette[0]), hexcolor(chainPalette[0]), FADE_SPEED)
+	n.cam = &canvas.cam
+	n.MoveTo(float64(x), float64(y))
 	canvas.nodes = append(canvas.nodes, n)
+	return n
+}
+
+func (canvas *CanvasPane) newInput(x, y int32) *Node {
+	n := canvas.newNode("input", "input", hexcolor(0x5be33b), x, y)
+	n.params = makeParams(inputParams)
+	canvas.inputMenu(n)
+	return n
+}
+
+// setInputFile loads the given file into an input node, as one clip
+func (canvas *CanvasPane) setInputFile(n *Node, filename string) {
+	clip, format := newClip(filename, 0)
+	canvas.setClips(n, []Clip{clip}, format)
+}
 
-	//n.menu.OnClick(func(entry *MenuEntry) {
-	//	log.Println("Input clicked: " + entry.Text)
-	//	n.args = make([]string, 1, 1)
-	// 	n.args[0] = entry.Text
-	// 	n.label.Text = entry.Text
-	// 	n.label.Update(canvas.rsc.renderer)
-	// 	if n.Pos.W < n.label.texwidth + 8 {
-	// 		n.Pos.W = n.label.texwidth + 8
-	// 	}
-	// 	if n.Pos.H < n.label.texheight + 8 {
-	// 		n.Pos.H = n.label.texheight + 8
-	// 	}
-	// 	n.label.Pos = n.Pos
-	// })
+// setClips puts clips on an input, named after the first file
+func (canvas *CanvasPane) setClips(n *Node, clips []Clip, format Format) {
+	n.clips = clips
+	n.format = format
+	if len(clips) > 0 {
+		n.SetLabel(canvas.rsc.renderer, clips[0].Name())
+	}
+}
+
+// menuPos returns the canvas position where the popup menu was opened
+func (canvas *CanvasPane) menuPos() (int32, int32) {
+	x, y := canvas.cam.ToWorld(canvas.menu.Pos.X, canvas.menu.Pos.Y)
+	return int32(x), int32(y)
+}
+
+func (canvas *CanvasPane) NewInput() {
+	n := canvas.newInput(canvas.menuPos())
+	canvas.openFile(func(filename string) {
+		canvas.setInputFile(n, filename)
+	})
 }
 
 func (canvas *CanvasPane) NewOutput() {
-	n := &Node{}
-	n.Pos = canvas.menu.Pos
-	n.Pos.W = 64
-	n.Pos.H = 48
-	n.color = hexcolor(0xff3015)
-	n.name = "output"
-	n.label.Init(canvas.rsc.renderer, n.Pos, n.name, canvas.rsc.TitleFont, hexcolor(0x303030))
-	canvas.nodes = append(canvas.nodes, n)
+	x, y := canvas.menuPos()
+	canvas.makeNode("output", x, y)
+}
+
+func (canvas *CanvasPane) NewMixer() {
+	x, y := canvas.menuPos()
+	canvas.makeNode("mixer", x, y)
+}
+
+func (canvas *CanvasPane) NewRouter() {
+	x, y := canvas.menuPos()
+	canvas.makeNode("router", x, y)
 }
 
 func (canvas *CanvasPane) NewEffect() {
-	n := &Node{}
-	n.Pos = canvas.menu.Pos
-	n.Pos.W = 64
-	n.Pos.H = 48
-	n.color = hexcolor(0xffe018)
-	n.name = "(null-fx)"
-	n.label.Init(canvas.rsc.renderer, n.Pos, n.name, canvas.rsc.TitleFont, hexcolor(0x303030))
+	x, y := canvas.menuPos()
+	canvas.makeNode("effect", x, y)
+}
+
+// makeNode creates an empty node of the given type
+func (canvas *CanvasPane) makeNode(name string, x, y int32) *Node {
+	switch name {
+	case "input":
+		return canvas.newInput(x, y)
+	case "output":
+		return canvas.newNode("output", "output", hexcolor(0xff3015), x, y)
+	case "mixer":
+		n := canvas.newNode("mixer", "mixer", hexcolor(0x694ae9), x, y)
+		canvas.mixerMenu(n)
+		return n
+	case "router":
+		return canvas.newRouter(x, y)
+	case "ducker":
+		return canvas.newDucker(x, y)
+	case "automix":
+		return canvas.newAutomix(x, y)
+	case "effect":
+		return canvas.newEffect(x, y)
+	}
+	log.Println("Unknown node type:", name)
+	return nil
+}
+
+// setEffect turns an effect node into the named effect,
+// with default parameters
+func (canvas *CanvasPane) setEffect(n *Node, effect string) {
+	n.effect = effect
+	n.params = makeParams(effectDefs[n.effect].Params)
+	n.listen.Set(false)
+	n.markers.Set(false)
+	if canvas.panel.node == n {
+		canvas.panel.Bind(nil)
+		canvas.panel.Bind(n)
+	}
+	n.SetLabel(canvas.rsc.renderer, effect)
+	canvas.effectMenu(n)
+}
+
+// AskSoxEffect asks for any SoX effect and its arguments, to run
+// wrapped up in the pipeline next to the native effects
+func (canvas *CanvasPane) AskSoxEffect(n *Node) {
+	text := strings.Join(append([]string{n.effect}, n.EffectArgs()...), " ")
+	canvas.askText("SoX effect and arguments", strings.TrimSpace(text), func(text string) {
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			return
+		}
+		if sox.FindEffect(fields[0]) == nil {
+			log.Println("Unknown effect:", fields[0])
+			return
+		}
+		canvas.setEffect(n, fields[0])
+		if _, native := effectDefs[fields[0]]; !native {
+			n.args = fields[1:]
+		}
+	})
+}
+
+func (canvas *CanvasPane) newEffect(x, y int32) *Node {
+	n := canvas.newNode("effect", "(null-fx)", hexcolor(0xffe018), x, y)
+	canvas.effectMenu(n)
+	return n
+}
 
-	effects := make([]string, 0, 10)
-	for i, h := range sox.GetEffectHandlers() {
-		if i < 10 {
-			effects = append(effects, h.Name())
+// effectMenu fills in the right-click menu of an effect node: the
+// effects to choose from, saved chains, and presets for the current effect
+func (canvas *CanvasPane) effectMenu(n *Node) {
+	effects := make([]string, 0, len(effectDefs))
+	for name := range effectDefs {
+		if effectDefs[name].Args == nil || sox.FindEffect(name) != nil {
+			effects = append(effects, name)
 		}
 	}
+	sort.Strings(effects)
+	effects = append(effects, "sox effect...")
+	for _, c := range canvas.chains {
+		effects = append(effects, CHAIN_PREFIX + c.Name)
+	}
+	presets := effectPresets(n.effect)
+	if n.effect != "" {
+		effects = append(effects, "bypass", "save preset...")
+		if n.effect == "deess" {
+			effects = append(effects, "listen")
+		}
+		if repairs(n) {
+			effects = append(effects, "show markers")
+		}
+		for _, p := range presets {
+			effects = append(effects, PRESET_PREFIX + p.Name)
+		}
+	}
+	n.menu.Destroy()
 	n.menu.Init(canvas.rsc.renderer, n.Pos, effects, canvas.rsc.TitleFont)
-	canvas.nodes = append(canvas.nodes, n)
 
 	n.menu.OnClick(func(entry *MenuEntry) {
-		n.label.Text = entry.Text
-		n.label.Update(canvas.rsc.renderer)
-		if n.Pos.W < n.label.texwidth + 8 {
-			n.Pos.W = n.label.texwidth + 8
+		if entry.Text == "sox effect..." {
+			canvas.AskSoxEffect(n)
+			return
+		}
+		if entry.Text == "bypass" || entry.Text == "listen" {
+			canvas.ToggleNode(n, entry.Text)
+			return
 		}
-		if n.Pos.H < n.label.texheight + 8 {
-			n.Pos.H = n.label.texheight + 8
+		if entry.Text == "show markers" {
+			canvas.ToggleNode(n, "markers")
+			return
+		}
+		if entry.Text == "save preset..." {
+			canvas.SavePreset(n)
+			return
+		}
+		if strings.HasPrefix(entry.Text, PRESET_PREFIX) {
+			name := strings.TrimPrefix(entry.Text, PRESET_PREFIX)
+			for _, p := range presets {
+				if p.Name == name {
+					canvas.ApplyPreset(n, p)
+					return
+				}
+			}
+		}
+		if strings.HasPrefix(entry.Text, CHAIN_PREFIX) {
+			name := strings.TrimPrefix(entry.Text, CHAIN_PREFIX)
+			for _, c := range canvas.chains {
+				if c.Name == name {
+					canvas.InsertChain(n, c)
+					return
+				}
+			}
 		}
-		n.label.Pos = n.Pos
+		canvas.setEffect(n, entry.Text)
 	})
 }
 
-func (canvas *CanvasPane) Draw(rend *sdl.Renderer) {
+// findNode returns the first node of the given type, or nil
+func (canvas *CanvasPane) findNode(name string) *Node {
+	for _, n := range canvas.nodes {
+		if n.name == name {
+			return n
+		}
+	}
+	return nil
+}
+
+// gridPos returns the canvas position of a cell in the import grid
+func (canvas *CanvasPane) gridPos(col, row int) (int32, int32) {
+	return GRID_MARGIN + int32(col) * GRID_COLUMN, GRID_MARGIN + int32(row) * GRID_ROW
+}
 
+// AddFiles creates an input node for each file, lined up in the
+// first grid column and linked to the default mixer. The mixer and
+// an output are created as well if the canvas doesn't have them.
+// A project file replaces the canvas instead.
+func (canvas *CanvasPane) AddFiles(files []string) {
+	var audio []string
+	for _, f := range files {
+		if isProject(f) {
+			canvas.LoadProject(f)
+		} else {
+			audio = append(audio, f)
+		}
+	}
+	files = audio
+	row := 0
 	for _, n := range canvas.nodes {
-		n.DrawLink(rend)
+		if n.name == "input" {
+			row++
+		}
+	}
+	first := row
+
+	var added []*Node
+	for _, f := range files {
+		if _, err := os.Stat(f); err != nil {
+			log.Println(err)
+			continue
+		}
+		x, y := canvas.gridPos(0, row)
+		n := canvas.newInput(x, y)
+		canvas.setInputFile(n, f)
+		added = append(added, n)
+		row++
+	}
+	if len(added) == 0 {
+		return
+	}
+
+	mixer := canvas.findNode("mixer")
+	if mixer == nil {
+		x, y := canvas.gridPos(1, (first + row - 1) / 2)
+		mixer = canvas.makeNode("mixer", x, y)
 	}
+	if mixer.next == nil {
+		out := canvas.findNode("output")
+		if out == nil {
+			x, y := canvas.gridPos(2, int((mixer.Pos.Y - GRID_MARGIN) / GRID_ROW))
+			out = canvas.makeNode("output", x, y)
+		}
+		mixer.next = out
+	}
+	for _, n := range added {
+		n.next = mixer
+	}
+}
+
+func (canvas *CanvasPane) Draw(rend *sdl.Renderer) {
+	rend.SetClipRect(&canvas.Pos)
+	canvas.DrawGrid(rend)
+	canvas.DrawLinks(rend)
+	canvas.UpdateFormats()
+	canvas.DrawFormats(rend)
 
 	for _, n := range canvas.nodes {
 		n.Draw(rend)
 	}
+	canvas.DrawToggles(rend)
+	canvas.DrawSelection(rend)
+	canvas.DrawWarnings(rend)
+	canvas.minimap.Draw(rend)
+	rend.SetClipRect(nil)
 
+	canvas.panel.Draw(rend)
 	canvas.menu.Draw(rend)
 
 	if canvas.new_link != nil {
 		_, x, y := sdl.GetMouseState()
 		n0 := canvas.nodes[*canvas.new_link]
 		rend.SetDrawColor(hexcolor(0xffffff))
-		pos := n0.GetPos()
+		pos := canvas.cam.Rect(n0.GetPos())
 		rend.DrawRect(&pos)
 		rend.SetDrawColor(hexcolor(0x694ae9))
-		rend.DrawLine(n0.GetPos().X + n0.GetPos().W/2, n0.GetPos().Y + n0.GetPos().H/2, int32(x), int32(y))
+		rend.DrawLine(pos.X + pos.W/2, pos.Y + pos.H/2, int32(x), int32(y))
 	}
 }
 
@@ -391,9 +760,103 @@ func (canvas *CanvasPane) UpdateLayout(space sdl.Rect) {
 	canvas.Pos.W = space.W
 	canvas.Pos.H = space.H - canvas.Pos.Y
 	canvas.menu.UpdateLayout(space)
+	canvas.panel.UpdateLayout(canvas.Pos)
+	canvas.minimap.UpdateLayout(canvas.Pos)
+	canvas.cam.SetView(canvas.Pos)
+}
+
+// nodeAt returns the index of the topmost node at the given
+// screen position, or -1
+func (canvas *CanvasPane) nodeAt(x, y int32) int {
+	for i := len(canvas.nodes) - 1; i >= 0; i-- {
+		pos := canvas.cam.Rect(canvas.nodes[i].GetPos())
+		if pos.Contains(x, y) {
+			return i
+		}
+	}
+	return -1
+}
+
+// ParamChanged is called when a node parameter is changed from the UI.
+// The engine reads parameters as it plays, so there is nothing to do.
+func (canvas *CanvasPane) ParamChanged(node *Node, p *Param) {
+	log.Println(node.label.Text, p.Name, "=", p.Format())
+}
+
+func (canvas *CanvasPane) OnMouseWheelEvent(event *sdl.MouseWheelEvent) bool {
+	if !canvas.panel.OnMouseWheelEvent(event) {
+		return false
+	}
+	_, x, y := sdl.GetMouseState()
+	mx, my := int32(x), int32(y)
+	if canvas.Pos.Contains(mx, my) && !canvas.panel.Contains(mx, my) && !canvas.minimap.Pos.Contains(mx, my) {
+		canvas.cam.ZoomAt(mx, my, math.Pow(ZOOM_STEP, float64(event.Y)))
+		return false
+	}
+	return true
+}
+
+func (canvas *CanvasPane) OnKeyboardEvent(event *sdl.KeyboardEvent) bool {
+	if event.State != sdl.PRESSED {
+		return true
+	}
+	ctrl := (sdl.GetModState() & sdl.KMOD_CTRL) != 0
+	switch event.Keysym.Keycode {
+	case sdl.K_f, sdl.K_HOME:
+		canvas.FitToContent()
+		return false
+	case sdl.K_c:
+		if ctrl {
+			canvas.Copy()
+			return false
+		}
+	case sdl.K_v:
+		if ctrl {
+			_, x, y := sdl.GetMouseState()
+			wx, wy := canvas.cam.ToWorld(int32(x), int32(y))
+			canvas.Paste(int32(wx), int32(wy))
+			return false
+		}
+	case sdl.K_d:
+		if ctrl {
+			canvas.Duplicate()
+			return false
+		}
+	case sdl.K_b:
+		canvas.toggleSelected("bypass")
+		return false
+	case sdl.K_m:
+		canvas.toggleSelected("mute")
+		return false
+	case sdl.K_k:
+		canvas.toggleSelected("key")
+		return false
+	case sdl.K_LEFT, sdl.K_RIGHT:
+		for _, n := range canvas.groupNodes() {
+			canvas.Nudge(n, nudgeStep(event.Keysym.Keycode))
+		}
+		return false
+	case sdl.K_s:
+		if !ctrl {
+			canvas.toggleSelected("solo")
+			return false
+		}
+	}
+	return true
 }
 
 func (canvas *CanvasPane) OnMouseMotionEvent(event *sdl.MouseMotionEvent) bool {
+	if canvas.banding {
+		return true
+	}
+	if canvas.panning {
+		canvas.cam.Pan(event.XRel, event.YRel)
+		return true
+	}
+	if !canvas.minimap.OnMouseMotionEvent(event) {
+		return true
+	}
+	canvas.panel.OnMouseMotionEvent(event)
 	if canvas.menu.Visible {
 		canvas.menu.OnMouseMotionEvent(event)
 	} else {
@@ -405,15 +868,26 @@ func (canvas *CanvasPane) OnMouseMotionEvent(event *sdl.MouseMotionEvent) bool {
 }
 
 func (canvas *CanvasPane) OnMouseButtonEvent(event *sdl.MouseButtonEvent) bool {
+	if !canvas.menu.Visible && !canvas.panel.OnMouseButtonEvent(event) {
+		return false
+	}
+	if event.State == sdl.PRESSED && canvas.panel.Contains(event.X, event.Y) {
+		return true
+	}
+	if !canvas.menu.Visible && !canvas.minimap.OnMouseButtonEvent(event) {
+		return false
+	}
+	if event.Button == sdl.BUTTON_MIDDLE {
+		canvas.panning = event.State == sdl.PRESSED && canvas.Pos.Contains(event.X, event.Y)
+		return !canvas.panning
+	}
+	if canvas.banding && event.State == sdl.RELEASED && event.Button == sdl.BUTTON_LEFT {
+		canvas.EndBand(event.X, event.Y)
+		return false
+	}
+
 	if event.State == sdl.RELEASED && canvas.new_link != nil {
-		to := -1
-		for i, n := range canvas.nodes {
-			pos := n.GetPos()
-			if pos.Contains(event.X, event.Y) {
-				to = i
-				break
-			}
-		}
+		to := canvas.nodeAt(event.X, event.Y)
 		if to != -1 && to != *canvas.new_link && canvas.nodes[to].name != "input" {
 			canvas.nodes[*canvas.new_link].next = canvas.nodes[to]
 		}
@@ -422,14 +896,7 @@ func (canvas *CanvasPane) OnMouseButtonEvent(event *sdl.MouseButtonEvent) bool {
 
 	if event.Button == sdl.BUTTON_RIGHT && event.State == sdl.PRESSED {
 		if !canvas.menu.Visible {
-			hitsbox := false
-			for _, n := range canvas.nodes {
-				p := n.GetPos()
-				if p.Contains(event.X, event.Y) {
-					hitsbox = true
-					break
-				}
-			}
+			hitsbox := canvas.nodeAt(event.X, event.Y) >= 0
 			if !hitsbox && canvas.Pos.Contains(event.X, event.Y) {
 				canvas.menu.Show(event.X, event.Y)
 				return false
@@ -443,102 +910,135 @@ func (canvas *CanvasPane) OnMouseButtonEvent(event *sdl.MouseButtonEvent) bool {
 	} else {
 		lpress := event.State == sdl.PRESSED && event.Button == sdl.BUTTON_LEFT
 		if ((sdl.GetModState() & sdl.KMOD_SHIFT) != 0) && lpress {
-			from := -1
-			for i, n := range canvas.nodes {
-				pos := n.GetPos()
-				if pos.Contains(event.X, event.Y) {
-					from = i
-					break
-				}
-			}
+			from := canvas.nodeAt(event.X, event.Y)
 			if from >= 0 && canvas.nodes[from].name != "output" {
 				log.Println("Start linking from node", from)
 				canvas.new_link = &from
 			}
 		} else {
+			if lpress && !canvas.PressSelect(event.X, event.Y) {
+				return false
+			}
+			var dropped []*Node
+			for _, n := range canvas.nodes {
+				if n.dragging && event.State == sdl.RELEASED {
+					dropped = append(dropped, n)
+				}
+			}
 			for _, n := range canvas.nodes {
 				if !n.OnMouseButtonEvent(event) {
 					break
 				}
 			}
+			if lpress {
+				canvas.dragGroup()
+			}
+			if len(dropped) > 0 {
+				canvas.SpliceOnLink(dropped)
+			}
 		}
 	}
 	return true
 }
 
-func (canvas *CanvasPane) BuildPlayChain() func() {
-	if canvas.playing != nil && canvas.playing.finished {
-		canvas.playing.Release()
-		canvas.playing = nil
+// pathFrom follows the links from node and returns the nodes passed
+// on the way, ending with the output. It returns nil if the links
+// never reach an output.
+func pathFrom(node *Node) []*Node {
+	seen := map[*Node]bool{node: true}
+	var path []*Node
+	for n := node.next; n != nil; n = n.next {
+		if seen[n] {
+			log.Println("Loop detected!")
+			return nil
+		}
+		seen[n] = true
+		path = append(path, n)
+		if n.name == "output" {
+			return path
+		}
 	}
+	return nil
+}
 
-	// find an input
-	var start *Node
-	for _, n := range canvas.nodes {
-		if n.name == "input" {
-			start = n
-			break
+func (canvas *CanvasPane) Play() {
+	if canvas.playing != nil {
+		if !canvas.playing.Finished() {
+			return
 		}
+		canvas.playing.Release()
+		canvas.playing = nil
 	}
-	if start == nil || len(start.args) != 1 {
+	g := canvas.buildGraph()
+	if g == nil {
 		log.Println("Nothing to play.")
-		return func() {}
+		return
 	}
-
-	var stop *Node
-	for n2 := start.next; n2 != nil; n2 = n2.next {
-		if n2 == start {
-			log.Println("Loop detected!")
-			return func() {}
-		} else if n2.name == "output" {
-			stop = n2
-		}
+	canvas.playing = NewEngine(g)
+	if canvas.playing == nil {
+		return
 	}
+	canvas.topology = canvas.topologyKey()
+	go canvas.playing.Flow()
+}
 
-	if stop == nil {
-		log.Println("Nothing to play to.")
-		return func() {}
+// UpdatePlayback hands the engine a new graph when the links change,
+// and moves the automated controls to where the playhead is. While
+// held, as when a clip is being dragged, the graph is left as it is
+// until let go.
+func (canvas *CanvasPane) UpdatePlayback(held bool) {
+	if canvas.playing == nil || canvas.playing.Finished() {
+		return
 	}
-
-	in := sox.OpenRead(start.args[0])
-	if in == nil {
-		log.Println("Failed to open input file.")
-		return func() {}
+	canvas.showAutomation(canvas.playing.playhead())
+	if held {
+		return
 	}
-	out := sox.OpenWrite("default", in.Signal(), nil, "alsa")
-	if out == nil {
-		log.Println("Failed to open output device.")
-		return func() {}
+	key := canvas.topologyKey()
+	if key == canvas.topology {
+		return
 	}
-	chain := sox.CreateEffectsChain(in.Encoding(), out.Encoding())
-
-	e := sox.CreateEffect(sox.FindEffect("input"))
-	e.Options(in)
-	chain.Add(e, in.Signal(), in.Signal())
-	e.Release()
-
-	e = sox.CreateEffect(sox.FindEffect("output"))
-	e.Options(out)
-	chain.Add(e, in.Signal(), in.Signal())
-	e.Release()
-
-	canvas.playing = &SoxChain{chain, in, out, false, false}
-
-	return func() {
-		canvas.playing.Flow()
+	canvas.topology = key
+	if g := canvas.buildGraph(); g != nil {
+		canvas.playing.Rebuild(g)
+	} else {
+		canvas.Stop()
 	}
 }
 
-func (canvas *CanvasPane) Play() {
+// showAutomation sets every automated parameter to its value at a
+// time, for the controls. The engines read the envelopes themselves.
+func (canvas *CanvasPane) showAutomation(t float64) {
+	for _, n := range canvas.nodes {
+		for _, p := range n.AllParams() {
+			if p.Automated() {
+				p.Set(p.At(t))
+			}
+		}
+	}
+}
 
-	fn := canvas.BuildPlayChain()
-	go fn()
-	//go canvas.playing.Flow()
+// Render mixes everything reaching the output into a file
+func (canvas *CanvasPane) Render(path string) {
+	g := canvas.buildGraph()
+	if g == nil {
+		log.Println("Nothing to render.")
+		return
+	}
+	e := NewRender(g, path)
+	if e == nil {
+		return
+	}
+	go func() {
+		e.Flow()
+		e.Release()
+		log.Println("Rendered", path)
+	}()
 }
 
 func (canvas *CanvasPane) Stop() {
 	if canvas.playing != nil {
-		canvas.playing.interrupt = true
+		canvas.playing.Stop()
 	}
 }
 
@@ -569,19 +1069,37 @@ func (screen *Screen) Init(space sdl.Rect, rsc *Resources, tracks []string) {
 	screen.AddVisual(screen.TopBar)
 	screen.AddLayout(screen.TopBar)
 
+	view := sdl.Rect{space.X, space.Y + TOPBAR_HEIGHT, space.W, space.H - TOPBAR_HEIGHT}
 	screen.Canvas = &CanvasPane{}
-	screen.Canvas.Init(rsc, sdl.Rect{space.X, space.Y + TOPBAR_HEIGHT, space.W, space.H - TOPBAR_HEIGHT}, tracks)
-	screen.AddVisual(screen.Canvas)
-	screen.AddLayout(screen.Canvas)
+	screen.Canvas.Init(rsc, view, tracks)
+	screen.Tracks = &TrackPane{}
+	screen.Tracks.Init(rsc, view, screen.Canvas)
+	screen.Views = &Views{}
+	screen.Views.Add(screen.Canvas)
+	screen.Views.Add(screen.Tracks)
+	screen.AddVisual(screen.Views)
+	screen.AddLayout(screen.Views)
+
+	screen.Files = &FileBrowser{}
+	screen.Files.Init(rsc)
+	screen.Canvas.openFile = screen.OpenFileDialog
+	screen.Text = &TextDialog{}
+	screen.Text.Init(rsc)
+	screen.Canvas.askText = screen.AskText
+	screen.Form = &FormDialog{}
+	screen.Form.Init(rsc)
+	screen.Canvas.askForm = screen.AskForm
 
 	screen.UpdateLayout(space)
 
 	screen.F1.OnClick(func() {
 		log.Println("Canvas Mode clicked!")
+		screen.ShowView(0, "canvas mode")
 	})
 
 	screen.F2.OnClick(func() {
 		log.Println("Track Mode clicked!")
+		screen.ShowView(1, "track mode")
 	})
 
 	screen.Play.OnClick(func() {
@@ -596,8 +1114,48 @@ func (screen *Screen) Init(space sdl.Rect, rsc *Resources, tracks []string) {
 
 }
 
+// ShowView switches between the canvas and the track view
+func (screen *Screen) ShowView(i int, title string) {
+	screen.Views.Show(i)
+	screen.Title.Text = title
+	screen.Title.Update(screen.rsc.renderer)
+}
+
+// OpenFileDialog shows the file browser as a modal dialog
+func (screen *Screen) OpenFileDialog(callback func(filename string)) {
+	screen.ShowModal(screen.Files)
+	screen.Files.Open(callback)
+}
+
+// AskText asks for a line of text in a modal dialog
+func (screen *Screen) AskText(title, text string, callback func(text string)) {
+	screen.ShowModal(screen.Text)
+	screen.Text.Ask(title, text, callback)
+}
+
+// AskForm asks for several lines of text in a modal dialog
+func (screen *Screen) AskForm(title string, names, values []string, callback func(values []string)) {
+	screen.ShowModal(screen.Form)
+	screen.Form.Ask(title, names, values, callback)
+}
+
+func (screen *Screen) ShowModal(modal Modal) {
+	screen.modal = modal
+	modal.UpdateLayout(screen.Pos)
+}
+
+func (screen *Screen) Destroy() {
+	screen.Pane.Destroy()
+	screen.Files.Destroy()
+	screen.Text.Destroy()
+	screen.Form.Destroy()
+}
+
 func (screen *Screen) UpdateAnimations(delta float64) {
-	// TODO
+	screen.Canvas.UpdateAnimations(delta)
+	screen.Canvas.UpdatePlayback(screen.Tracks.dragging())
+	screen.Canvas.UpdateAlignments()
+	screen.Canvas.UpdateDrifts()
 }
 
 func studioSetup(window *sdl.Window, rend *sdl.Renderer, tracks []string) *Screen {
@@ -613,7 +1171,7 @@ func studioSetup(window *sdl.Window, rend *sdl.Renderer, tracks []string) *Scree
 	screen.stack.Add(screen.F2)
 	screen.stack.Add(screen.Play)
 	screen.stack.Add(screen.Stop)
-	screen.stack.Add(screen.Canvas)
+	screen.stack.Add(screen.Views)
 	//defer screen.Destroy()
 
 	screen.framerate = gfx.NewFramerate()
@@ -629,33 +1187,80 @@ func studioUpdate(window *sdl.Window, rend *sdl.Renderer, screen *Screen) bool {
 	var event sdl.Event
 	running := true
 	for (&event).Poll() {
+		if screen.modal != nil && !screen.modal.IsOpen() {
+			screen.modal = nil
+		}
 		switch e := (&event).Get().(type) {
 		case sdl.QuitEvent:
 			running = false
 
 		case sdl.KeyboardEvent:
-			if e.Keysym.Keycode == sdl.K_ESCAPE {
+			if screen.modal != nil {
+				screen.modal.OnKeyboardEvent(&e)
+			} else if e.Keysym.Keycode == sdl.K_ESCAPE {
 				running = false
+			} else if e.Keysym.Keycode == sdl.K_F1 && e.State == sdl.PRESSED {
+				screen.ShowView(0, "canvas mode")
+			} else if e.Keysym.Keycode == sdl.K_F2 && e.State == sdl.PRESSED {
+				screen.ShowView(1, "track mode")
+			} else {
+				screen.stack.OnKeyboardEvent(&e)
 			}
 
 		case sdl.MouseMotionEvent:
-			screen.stack.OnMouseMotionEvent(&e)
+			if screen.modal != nil {
+				screen.modal.OnMouseMotionEvent(&e)
+			} else {
+				screen.stack.OnMouseMotionEvent(&e)
+			}
 
 		case sdl.MouseButtonEvent:
-			screen.stack.OnMouseButtonEvent(&e)
+			if screen.modal != nil {
+				screen.modal.OnMouseButtonEvent(&e)
+			} else {
+				screen.stack.OnMouseButtonEvent(&e)
+			}
+
+		case sdl.MouseWheelEvent:
+			if screen.modal != nil {
+				screen.modal.OnMouseWheelEvent(&e)
+			} else {
+				screen.stack.OnMouseWheelEvent(&e)
+			}
+
+		case sdl.TextInputEvent:
+			if lover, ok := screen.modal.(TextLover); ok {
+				lover.OnTextInputEvent(&e)
+			}
+
+		case sdl.DropEvent:
+			screen.Canvas.AddFiles([]string{e.File})
 		}
 	}
+	if screen.modal != nil && !screen.modal.IsOpen() {
+		screen.modal = nil
+	}
 
 	neww, newh := window.GetSize()
 	screen.UpdateLayout(sdl.Rect{0, 0, int32(neww), int32(newh)})
+	if screen.modal != nil {
+		screen.modal.UpdateLayout(screen.Pos)
+	}
 
-	//screen.UpdateAnimations(framerate.Delta())
+	now := sdl.GetTicks()
+	if screen.lastframe != 0 {
+		screen.UpdateAnimations(float64(now - screen.lastframe) / 1000.0)
+	}
+	screen.lastframe = now
 
 	rend.SetDrawBlendMode(sdl.BLENDMODE_NONE)
 	rend.SetDrawColor(screen.rsc.BackgroundColor)
 	rend.Clear()
 	rend.SetDrawBlendMode(sdl.BLENDMODE_BLEND)
 	screen.Draw(rend)
+	if screen.modal != nil {
+		screen.modal.Draw(rend)
+	}
 	rend.Present()
 	screen.framerate.FramerateDelay()
 
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+
+	"github.com/krig/Go-SDL2/sdl"
+)
+
+const (
+	// how long mute, solo and bypass take to fade, short enough to
+	// feel instant and long enough not to click
+	RAMP_MS = 10
+	BADGE_SIZE = int32(12)
+)
+
+// Toggle is an on/off switch flipped by the UI and read by the player
+type Toggle struct {
+	on int32
+}
+
+func (t *Toggle) Get() bool {
+	return atomic.LoadInt32(&t.on) != 0
+}
+
+func (t *Toggle) Set(on bool) {
+	v := int32(0)
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&t.on, v)
+}
+
+// Flip switches the toggle and returns the new state
+func (t *Toggle) Flip() bool {
+	on := !t.Get()
+	t.Set(on)
+	return on
+}
+
+// Ramp moves a gain towards its target a little every frame, so
+// switching something on or off doesn't click
+type Ramp struct {
+	gain float64
+	step float64
+}
+
+// Init sets the starting gain and how many milliseconds it takes
+// to ramp from 0 to 1
+func (r *Ramp) Init(rate, ms, gain float64) {
+	r.gain = gain
+	r.step = 1000 / (ms * rate)
+}
+
+// Next returns the gain for the next frame
+func (r *Ramp) Next(target float64) float64 {
+	if r.gain < target {
+		r.gain += r.step
+		if r.gain > target {
+			r.gain = target
+		}
+	} else if r.gain > target {
+		r.gain -= r.step
+		if r.gain < target {
+			r.gain = target
+		}
+	}
+	return r.gain
+}
+
+// soloing is true if any of the nodes is soloed
+func soloing(nodes []*Node) bool {
+	for _, n := range nodes {
+		if n.solo.Get() {
+			return true
+		}
+	}
+	return false
+}
+
+// audible is true if an input should be heard, given whether
+// something is soloed
+func (node *Node) audible(soloing bool) bool {
+	return !node.mute.Get() && (!soloing || node.solo.Get())
+}
+
+// ToggleNode flips the named switch on a node: bypass on effects,
+// mute and solo on inputs, key on anything, listen on de-essers and
+// markers on declickers and declippers
+func (canvas *CanvasPane) ToggleNode(node *Node, what string) {
+	var t *Toggle
+	switch {
+	case what == "bypass" && node.name == "effect":
+		t = &node.bypass
+	case what == "mute" && node.name == "input":
+		t = &node.mute
+	case what == "solo" && node.name == "input":
+		t = &node.solo
+	case what == "key":
+		t = &node.key
+	case what == "listen" && node.effect == "deess":
+		t = &node.listen
+	case what == "markers" && repairs(node):
+		t = &node.markers
+	default:
+		return
+	}
+	log.Println(node.label.Text, what, "=", t.Flip())
+}
+
+// toggleSelected flips a switch on every selected node
+func (canvas *CanvasPane) toggleSelected(what string) {
+	for _, n := range canvas.groupNodes() {
+		canvas.ToggleNode(n, what)
+	}
+}
+
+// inputMenu fills in the right-click menu of an input node
+func (canvas *CanvasPane) inputMenu(n *Node) {
+	entries := append([]string{"mute", "solo", "key", "reference", "align to reference", "correct drift"}, lawEntries()...)
+	n.menu.Init(canvas.rsc.renderer, n.Pos, entries, canvas.rsc.TitleFont)
+	n.menu.OnClick(func(entry *MenuEntry) {
+		switch {
+		case setLaw(n, entry.Text):
+		case entry.Text == "reference":
+			canvas.SetReference(n)
+		case entry.Text == "align to reference":
+			canvas.AlignToReference(n)
+		case entry.Text == "correct drift":
+			canvas.CorrectDrift(n)
+		default:
+			canvas.ToggleNode(n, entry.Text)
+		}
+	})
+}
+
+// DrawToggles puts a badge on the corner of each node for every
+// switch that is on, and dims bypassed effects
+func (canvas *CanvasPane) DrawToggles(rend *sdl.Renderer) {
+	for _, n := range canvas.nodes {
+		pos := canvas.cam.Rect(n.GetPos())
+		x := pos.X + 2
+		badge := func(text string, clr sdl.Color) {
+			box := sdl.Rect{x, pos.Y + 2, BADGE_SIZE, BADGE_SIZE}
+			rend.SetDrawColor(clr)
+			rend.FillRect(&box)
+			label := canvas.badges[text]
+			label.Pos = box
+			label.Draw(rend)
+			x += BADGE_SIZE + 2
+		}
+		if n.bypass.Get() {
+			rend.SetDrawColor(canvas.rsc.BackgroundColor)
+			rend.DrawLine(pos.X, pos.Y + pos.H, pos.X + pos.W, pos.Y)
+			badge("B", hexcolor(0x808080))
+		}
+		if n.mute.Get() {
+			badge("M", hexcolor(0xff3015))
+		}
+		if n.solo.Get() {
+			badge("S", hexcolor(0xffe018))
+		}
+		if n.key.Get() {
+			badge("K", hexcolor(0x15f0e1))
+		}
+		if n.listen.Get() {
+			badge("L", hexcolor(0xffe018))
+		}
+		if n == canvas.reference {
+			badge("R", hexcolor(0xeeeeec))
+		}
+	}
+}
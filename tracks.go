@@ -0,0 +1,866 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strings"
+
+	"github.com/krig/Go-SDL2/sdl"
+)
+
+const (
+	TRACK_HEIGHT = 112
+	TRACK_HEADER = 160
+	CLIP_HEIGHT = 56
+	POINT_SIZE = 6
+	// how close to the edge of a clip a drag trims it
+	EDGE_SIZE = 5
+	// pixels per second when the track view opens
+	TRACK_ZOOM = 20
+)
+
+// what dragging a clip does
+const (
+	CLIP_MOVE = iota
+	CLIP_TRIM_START
+	CLIP_TRIM_END
+	CLIP_FADE_IN
+	CLIP_FADE_OUT
+)
+
+const (
+	FADE_IN_PREFIX = "fade in: "
+	FADE_OUT_PREFIX = "fade out: "
+)
+
+// lane is a parameter that can be automated on a track
+type lane struct {
+	node *Node
+	param *Param
+}
+
+// TrackPane shows the inputs on the canvas as tracks along a shared
+// timeline. Each track has its clips on top and an automation lane
+// for one of the parameters on its way to the output below; clicking
+// the header picks the next one.
+//
+// Clips are moved by dragging, trimmed by dragging their edges and
+// faded with the handles in their top corners. Dragging from between
+// clips, or anywhere with shift held, selects a region of time.
+// Right-clicking a clip splits it at the cursor, loops it, or changes
+// its fades. Clicking a
+// lane adds a breakpoint, breakpoints can be dragged, and
+// right-clicking one removes it.
+type TrackPane struct {
+	Widget
+	rsc *Resources
+	canvas *CanvasPane
+	menu PopupMenu
+	// seconds at the left edge, and pixels per second
+	scroll float64
+	zoom float64
+	panning bool
+	// set by clicking between clips, where splits happen, and
+	// dragging from there selects a region
+	cursor float64
+	selection Region
+	selecting bool
+	// the lane shown on each track
+	current map[*Node]int
+	labels map[string]*Label
+	// the breakpoint being dragged
+	drag *Param
+	dragIndex int
+	dragLane sdl.Rect
+	// the clip being dragged, how, and where it was grabbed
+	dragTrack *Node
+	dragClip int
+	dragMode int
+	dragGrab float64
+	dragArea sdl.Rect
+	// the last silence analysis, and where the next one arrives
+	silence *silenceResult
+	analysis chan *silenceResult
+	// what the repair effects found on each track, and where scans
+	// arrive
+	events map[*Node]*eventScan
+	scans chan *eventScan
+}
+
+func (tp *TrackPane) Init(rsc *Resources, space sdl.Rect, canvas *CanvasPane) {
+	tp.rsc = rsc
+	tp.Pos = space
+	tp.canvas = canvas
+	tp.zoom = TRACK_ZOOM
+	tp.current = make(map[*Node]int)
+	tp.labels = make(map[string]*Label)
+	tp.analysis = make(chan *silenceResult, 1)
+	tp.events = make(map[*Node]*eventScan)
+	tp.scans = make(chan *eventScan, 8)
+}
+
+func (tp *TrackPane) UpdateLayout(space sdl.Rect) {
+	tp.Pos.W = space.W
+	tp.Pos.H = space.H - tp.Pos.Y
+}
+
+func (tp *TrackPane) Destroy() {
+	for _, l := range tp.labels {
+		l.Destroy()
+	}
+	tp.menu.Destroy()
+}
+
+// tracks returns the input nodes, in canvas order
+func (tp *TrackPane) tracks() []*Node {
+	var tracks []*Node
+	for _, n := range tp.canvas.nodes {
+		if n.name == "input" {
+			tracks = append(tracks, n)
+		}
+	}
+	return tracks
+}
+
+// lanes returns the parameters of a track and of every node on its
+// way to the output
+func lanes(track *Node) []lane {
+	var lanes []lane
+	for _, n := range append([]*Node{track}, pathFrom(track)...) {
+		for _, p := range n.AllParams() {
+			lanes = append(lanes, lane{n, p})
+		}
+	}
+	return lanes
+}
+
+// laneOf returns the lane shown on a track
+func (tp *TrackPane) laneOf(track *Node) (lane, bool) {
+	ls := lanes(track)
+	if len(ls) == 0 {
+		return lane{}, false
+	}
+	return ls[tp.current[track] % len(ls)], true
+}
+
+// rows returns the header, clip and lane rectangles of track number i
+func (tp *TrackPane) rows(i int) (sdl.Rect, sdl.Rect, sdl.Rect) {
+	y := tp.Pos.Y + int32(i) * TRACK_HEIGHT
+	x := tp.Pos.X + TRACK_HEADER
+	w := tp.Pos.W - TRACK_HEADER
+	header := sdl.Rect{tp.Pos.X, y, TRACK_HEADER, TRACK_HEIGHT - 1}
+	clips := sdl.Rect{x, y + 4, w, CLIP_HEIGHT}
+	lane := sdl.Rect{x, y + CLIP_HEIGHT + 8, w, TRACK_HEIGHT - CLIP_HEIGHT - 13}
+	return header, clips, lane
+}
+
+// timeline returns the part of the pane right of the headers
+func (tp *TrackPane) timeline() sdl.Rect {
+	return sdl.Rect{tp.Pos.X + TRACK_HEADER, tp.Pos.Y, tp.Pos.W - TRACK_HEADER, tp.Pos.H}
+}
+
+func (tp *TrackPane) toX(area sdl.Rect, t float64) int32 {
+	return area.X + int32((t - tp.scroll) * tp.zoom)
+}
+
+func (tp *TrackPane) toTime(area sdl.Rect, x int32) float64 {
+	return math.Max(0, tp.scroll + float64(x - area.X) / tp.zoom)
+}
+
+func toY(area sdl.Rect, p *Param, v float64) int32 {
+	return area.Y + area.H - int32(p.NormOf(v) * float64(area.H))
+}
+
+func toValue(area sdl.Rect, p *Param, y int32) float64 {
+	return p.FromNorm(float64(area.Y + area.H - y) / float64(area.H))
+}
+
+func (tp *TrackPane) label(rend *sdl.Renderer, text string) *Label {
+	l := tp.labels[text]
+	if l == nil {
+		l = &Label{}
+		l.Init(rend, sdl.Rect{}, text, tp.rsc.TitleFont, tp.rsc.TitleColor)
+		tp.labels[text] = l
+	}
+	return l
+}
+
+func (tp *TrackPane) Draw(rend *sdl.Renderer) {
+	select {
+	case res := <-tp.analysis:
+		tp.silence = res
+		log.Println("Found", len(res.common), "pauses on all tracks")
+	default:
+	}
+	for done := false; !done; {
+		select {
+		case res := <-tp.scans:
+			if s := tp.events[res.track]; s != nil {
+				s.markers, s.scanning = res.markers, false
+			}
+		default:
+			done = true
+		}
+	}
+	rend.SetClipRect(&tp.Pos)
+	tp.drawRuler(rend)
+	tp.drawPauses(rend)
+	tp.drawSelection(rend)
+	tp.drawChapters(rend)
+	for i, track := range tp.tracks() {
+		header, clips, area := tp.rows(i)
+		rend.SetDrawColor(tp.rsc.TitleBarColor)
+		rend.FillRect(&header)
+		rend.SetDrawColor(darken(tp.rsc.BackgroundColor, 10))
+		rend.FillRect(&area)
+		title := track.label.Text
+		if track == tp.canvas.reference {
+			title += " (reference)"
+		} else if track.aligned > 0 {
+			title = fmt.Sprintf("%s (%.0f%%)", title, track.aligned * 100)
+		}
+		name := tp.label(rend, title)
+		name.Pos = sdl.Rect{header.X, header.Y, header.W, header.H / 2}
+		name.Draw(rend)
+		tp.drawClips(rend, clips, track)
+		tp.drawSilence(rend, clips, track)
+		tp.drawMarkers(rend, clips, track)
+
+		l, ok := tp.laneOf(track)
+		if !ok {
+			continue
+		}
+		what := l.param.Name
+		if l.node != track {
+			what = l.node.label.Text + ": " + what
+		}
+		lanename := tp.label(rend, what)
+		lanename.Pos = sdl.Rect{header.X, header.Y + header.H / 2, header.W, header.H / 2}
+		lanename.Draw(rend)
+		tp.drawEnvelope(rend, area, l.param, track.color)
+	}
+	timeline := tp.timeline()
+	x := tp.toX(timeline, tp.cursor)
+	rend.SetDrawColor(hexcolor(0xeeeeec))
+	rend.DrawLine(x, timeline.Y, x, timeline.Y + timeline.H)
+	tp.drawPlayhead(rend)
+	rend.SetClipRect(nil)
+	tp.menu.Draw(rend)
+}
+
+// drawRuler marks the seconds, further apart when zoomed out
+func (tp *TrackPane) drawRuler(rend *sdl.Renderer) {
+	step := 1.0
+	for _, s := range []float64{1, 5, 10, 30, 60, 300, 600} {
+		step = s
+		if s * tp.zoom >= 40 {
+			break
+		}
+	}
+	area := tp.timeline()
+	rend.SetDrawColor(darken(tp.rsc.BackgroundColor, 20))
+	for t := math.Ceil(tp.scroll / step) * step; tp.toX(area, t) < area.X + area.W; t += step {
+		x := tp.toX(area, t)
+		rend.DrawLine(x, area.Y, x, area.Y + area.H)
+	}
+}
+
+// clipRect returns where a clip is drawn in the clip row of its track
+func (tp *TrackPane) clipRect(area sdl.Rect, c *Clip) sdl.Rect {
+	x0, x1 := tp.toX(area, c.Start), tp.toX(area, c.End())
+	return sdl.Rect{x0, area.Y, x1 - x0, area.H}
+}
+
+// drawClips draws the clips of a track with their fades and the
+// points where a looped clip starts over
+func (tp *TrackPane) drawClips(rend *sdl.Renderer, area sdl.Rect, track *Node) {
+	for i := range track.clips {
+		c := &track.clips[i]
+		r := tp.clipRect(area, c)
+		rend.SetDrawColor(darken(track.color, 60))
+		rend.FillRect(&r)
+		rend.SetDrawColor(track.color)
+		rend.DrawRect(&r)
+		bottom := r.Y + r.H - 1
+		fin, fout := tp.toX(area, c.Start + c.FadeIn), tp.toX(area, c.End() - c.FadeOut)
+		rend.DrawLine(r.X, bottom, fin, r.Y)
+		rend.DrawLine(fout, r.Y, r.X + r.W - 1, bottom)
+		for _, x := range []int32{fin, fout} {
+			handle := sdl.Rect{x - POINT_SIZE / 2, r.Y, POINT_SIZE, POINT_SIZE}
+			rend.FillRect(&handle)
+		}
+		if c.Loop > 0 {
+			rend.SetDrawColor(lighten(track.color, 30))
+			for t := c.Start + (c.Loop - c.Skip) / c.speed(); t < c.End(); t += c.Loop / c.speed() {
+				x := tp.toX(area, t)
+				rend.DrawLine(x, r.Y + r.H / 2, x, bottom)
+			}
+		}
+		name := tp.label(rend, c.Name())
+		name.Pos = sdl.Rect{r.X, r.Y + POINT_SIZE, r.W, r.H / 2}
+		if name.texwidth < r.W {
+			name.Draw(rend)
+		}
+	}
+}
+
+// drawPauses shades where every track is silent
+func (tp *TrackPane) drawPauses(rend *sdl.Renderer) {
+	if tp.silence == nil || !tp.silence.current() {
+		return
+	}
+	area := tp.timeline()
+	rend.SetDrawColor(sdl.Color{0x15, 0xf0, 0xe1, 0x30})
+	for _, r := range tp.silence.common {
+		x0, x1 := tp.toX(area, r.Start), tp.toX(area, math.Min(r.End, tp.scroll + float64(area.W) / tp.zoom))
+		box := sdl.Rect{x0, area.Y, x1 - x0, area.H}
+		rend.FillRect(&box)
+	}
+}
+
+// updateMarkers starts scanning a track again when what its markers
+// depend on has changed, one scan at a time
+func (tp *TrackPane) updateMarkers(track *Node) *eventScan {
+	key, nodes := markersKey(track)
+	if key == "" {
+		delete(tp.events, track)
+		return nil
+	}
+	s := tp.events[track]
+	if s == nil {
+		s = &eventScan{track: track}
+		tp.events[track] = s
+	}
+	if s.key != key && !s.scanning {
+		s.key, s.scanning = key, true
+		t := track.snapshot()
+		var procs []Processor
+		for _, n := range nodes {
+			procs = append(procs, newProcessor(n, copyParams(n.params), t.format))
+		}
+		go func() {
+			tp.scans <- &eventScan{track: track, key: key, markers: scanEvents(t, procs)}
+		}()
+	}
+	return s
+}
+
+// drawMarkers ticks the clicks and clipping found on a track along
+// the top of its clips
+func (tp *TrackPane) drawMarkers(rend *sdl.Renderer, area sdl.Rect, track *Node) {
+	s := tp.updateMarkers(track)
+	if s == nil {
+		return
+	}
+	for _, m := range s.markers {
+		x := tp.toX(area, m.time)
+		if x < area.X || x >= area.X + area.W {
+			continue
+		}
+		if m.clip {
+			rend.SetDrawColor(hexcolor(0xff3015))
+		} else {
+			rend.SetDrawColor(hexcolor(0xffe018))
+		}
+		rend.DrawLine(x, area.Y, x, area.Y + area.H / 4)
+	}
+}
+
+// drawSelection shades the selected region
+func (tp *TrackPane) drawSelection(rend *sdl.Renderer) {
+	if tp.selection.Length() <= 0 {
+		return
+	}
+	area := tp.timeline()
+	x0, x1 := tp.toX(area, tp.selection.Start), tp.toX(area, tp.selection.End)
+	box := sdl.Rect{x0, area.Y, x1 - x0, area.H}
+	rend.SetDrawColor(sdl.Color{0xee, 0xee, 0xec, 0x20})
+	rend.FillRect(&box)
+}
+
+// drawChapters draws a line across the tracks at each chapter, with
+// its title at the top
+func (tp *TrackPane) drawChapters(rend *sdl.Renderer) {
+	area := tp.timeline()
+	for _, c := range tp.canvas.chapters {
+		x := tp.toX(area, c.Time)
+		if x < area.X || x >= area.X + area.W {
+			continue
+		}
+		rend.SetDrawColor(hexcolor(0xad7fa8))
+		rend.DrawLine(x, area.Y, x, area.Y + area.H)
+		name := tp.label(rend, c.Title)
+		name.Pos = sdl.Rect{x + 2, area.Y, name.texwidth, ROW_HEIGHT}
+		name.Draw(rend)
+	}
+}
+
+// chapterAt returns the chapter at an x position, or -1
+func (tp *TrackPane) chapterAt(area sdl.Rect, x int32) int {
+	for i, c := range tp.canvas.chapters {
+		if abs32(x - tp.toX(area, c.Time)) <= EDGE_SIZE {
+			return i
+		}
+	}
+	return -1
+}
+
+// drawSilence marks the silence found on a track along the bottom
+// of its clips
+func (tp *TrackPane) drawSilence(rend *sdl.Renderer, area sdl.Rect, track *Node) {
+	if tp.silence == nil || !tp.silence.current() {
+		return
+	}
+	rend.SetDrawColor(hexcolor(0x15f0e1))
+	for _, r := range tp.silence.tracks[track] {
+		x0, x1 := tp.toX(area, r.Start), tp.toX(area, math.Min(r.End, tp.scroll + float64(area.W) / tp.zoom))
+		box := sdl.Rect{x0, area.Y + area.H - 3, x1 - x0, 3}
+		rend.FillRect(&box)
+	}
+}
+
+// headerMenu finds silence on the tracks and shortens the pauses, or
+// learns the noise of a track
+func (tp *TrackPane) headerMenu(x, y int32, track *Node) {
+	tp.showMenu(x, y, []string{"find silence...", "shorten pauses...", "learn noise profile", "add chapter at cursor..."}, func(entry string) {
+		if tp.chapterEntry(entry, tp.cursor, -1) {
+			return
+		}
+		switch entry {
+		case "learn noise profile":
+			tp.canvas.LearnNoise(track, tp.selection)
+		case "find silence...":
+			tp.canvas.askText("silence below dB, for at least seconds", "-45 0.5", tp.FindSilence)
+		case "shorten pauses...":
+			tp.canvas.askText("shorten pauses longer than, down to seconds", "2 0.7", tp.ShortenPauses)
+		}
+	})
+}
+
+// FindSilence analyses every track in the background, given the
+// threshold in dB and the shortest silence in seconds
+func (tp *TrackPane) FindSilence(text string) {
+	var threshold, minLength float64
+	if _, err := fmt.Sscan(text, &threshold, &minLength); err != nil {
+		log.Println("Expected a threshold and a length:", err)
+		return
+	}
+	tracks := snapshotTracks(tp.canvas.nodes)
+	go func() {
+		tp.analysis <- analyseSilence(tracks, threshold, minLength)
+	}()
+}
+
+// ShortenPauses cuts the pauses found by the last analysis, given
+// how long a pause has to be and how long it ends up, in seconds
+func (tp *TrackPane) ShortenPauses(text string) {
+	var longer, short float64
+	if _, err := fmt.Sscan(text, &longer, &short); err != nil || short > longer {
+		log.Println("Expected two lengths, the first the longer:", text)
+		return
+	}
+	if tp.silence == nil || !tp.silence.current() {
+		log.Println("Find the silence first.")
+		return
+	}
+	tp.canvas.ShortenPauses(tp.silence, longer, short)
+	tp.silence = nil
+}
+
+// drawEnvelope draws the automation of a parameter, or its value as
+// a dim line when it isn't automated
+func (tp *TrackPane) drawEnvelope(rend *sdl.Renderer, area sdl.Rect, p *Param, color sdl.Color) {
+	env := p.Envelope()
+	if len(env) == 0 {
+		y := toY(area, p, p.Get())
+		rend.SetDrawColor(darken(color, 40))
+		rend.DrawLine(area.X, y, area.X + area.W, y)
+		return
+	}
+	rend.SetDrawColor(color)
+	px, py := area.X, toY(area, p, env[0].Value)
+	for _, b := range env {
+		x, y := tp.toX(area, b.Time), toY(area, p, b.Value)
+		rend.DrawLine(px, py, x, y)
+		point := sdl.Rect{x - POINT_SIZE / 2, y - POINT_SIZE / 2, POINT_SIZE, POINT_SIZE}
+		rend.FillRect(&point)
+		px, py = x, y
+	}
+	rend.DrawLine(px, py, area.X + area.W, py)
+}
+
+func (tp *TrackPane) drawPlayhead(rend *sdl.Renderer) {
+	e := tp.canvas.playing
+	if e == nil || e.Finished() {
+		return
+	}
+	area := tp.timeline()
+	x := tp.toX(area, e.playhead())
+	rend.SetDrawColor(hexcolor(0xff3015))
+	rend.DrawLine(x, area.Y, x, area.Y + area.H)
+}
+
+// pointAt returns the index of the breakpoint at a screen position, or -1
+func (tp *TrackPane) pointAt(area sdl.Rect, p *Param, x, y int32) int {
+	for i, b := range p.Envelope() {
+		dx, dy := tp.toX(area, b.Time) - x, toY(area, p, b.Value) - y
+		if dx * dx + dy * dy <= POINT_SIZE * POINT_SIZE {
+			return i
+		}
+	}
+	return -1
+}
+
+// clipAt returns the clip of a track at a screen position and what
+// dragging it there does, or -1
+func (tp *TrackPane) clipAt(area sdl.Rect, track *Node, x, y int32) (int, int) {
+	for i := len(track.clips) - 1; i >= 0; i-- {
+		c := &track.clips[i]
+		r := tp.clipRect(area, c)
+		if x < r.X - EDGE_SIZE || x > r.X + r.W + EDGE_SIZE || y < r.Y || y > r.Y + r.H {
+			continue
+		}
+		if y < r.Y + POINT_SIZE * 2 {
+			if abs32(x - tp.toX(area, c.Start + c.FadeIn)) <= POINT_SIZE {
+				return i, CLIP_FADE_IN
+			}
+			if abs32(x - tp.toX(area, c.End() - c.FadeOut)) <= POINT_SIZE {
+				return i, CLIP_FADE_OUT
+			}
+		}
+		if abs32(x - r.X) <= EDGE_SIZE {
+			return i, CLIP_TRIM_START
+		}
+		if abs32(x - r.X - r.W) <= EDGE_SIZE {
+			return i, CLIP_TRIM_END
+		}
+		if x >= r.X && x <= r.X + r.W {
+			return i, CLIP_MOVE
+		}
+	}
+	return -1, CLIP_MOVE
+}
+
+func abs32(x int32) int32 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// trackAt returns the number of the track at a screen position, or -1
+func (tp *TrackPane) trackAt(x, y int32) int {
+	for i := range tp.tracks() {
+		header, _, _ := tp.rows(i)
+		if y >= header.Y && y < header.Y + TRACK_HEIGHT {
+			return i
+		}
+	}
+	return -1
+}
+
+// showMenu opens the popup menu with the given entries
+func (tp *TrackPane) showMenu(x, y int32, entries []string, handler func(entry string)) {
+	tp.menu.Destroy()
+	tp.menu.Init(tp.rsc.renderer, sdl.Rect{x, y, 1, 1}, entries, tp.rsc.TitleFont)
+	tp.menu.OnClick(func(entry *MenuEntry) {
+		handler(entry.Text)
+	})
+	tp.menu.Show(x, y)
+}
+
+// clipMenu offers the edits of a clip, clicked at a time, and the
+// chapters there
+func (tp *TrackPane) clipMenu(x, y int32, track *Node, i int, t float64, chapter int) {
+	entries := []string{"split at cursor", "loop", "delete clip"}
+	if track.clips[i].Loop > 0 {
+		entries[1] = "stop looping"
+	}
+	for _, c := range fadeCurves {
+		entries = append(entries, FADE_IN_PREFIX + c)
+	}
+	for _, c := range fadeCurves {
+		entries = append(entries, FADE_OUT_PREFIX + c)
+	}
+	entries = append(entries, chapterEntries(chapter)...)
+	tp.showMenu(x, y, entries, func(entry string) {
+		if tp.chapterEntry(entry, t, chapter) || i >= len(track.clips) {
+			return
+		}
+		c := &track.clips[i]
+		switch {
+		case entry == "split at cursor":
+			if right, ok := c.Split(tp.cursor); ok {
+				track.clips = append(track.clips, right)
+			}
+		case entry == "loop":
+			c.SetLoop(true)
+		case entry == "stop looping":
+			c.SetLoop(false)
+		case entry == "delete clip":
+			track.clips = append(track.clips[:i], track.clips[i + 1:]...)
+		case strings.HasPrefix(entry, FADE_IN_PREFIX):
+			c.InCurve = strings.TrimPrefix(entry, FADE_IN_PREFIX)
+		case strings.HasPrefix(entry, FADE_OUT_PREFIX):
+			c.OutCurve = strings.TrimPrefix(entry, FADE_OUT_PREFIX)
+		}
+	})
+}
+
+// AddClip opens a file and puts it on a track at the given time
+func (tp *TrackPane) AddClip(track *Node, t float64) {
+	tp.canvas.openFile(func(filename string) {
+		clip, format := newClip(filename, t)
+		if len(track.clips) == 0 {
+			tp.canvas.setClips(track, []Clip{clip}, format)
+			return
+		}
+		if format.Rate != track.format.Rate {
+			log.Println("Clip is at", format, "and is resampled to the track's", track.format, "as it plays:", filename)
+		}
+		track.clips = append(track.clips, clip)
+	})
+}
+
+func (tp *TrackPane) OnMouseButtonEvent(event *sdl.MouseButtonEvent) bool {
+	if tp.menu.Visible {
+		if event.State == sdl.PRESSED && !tp.menu.Pos.Contains(event.X, event.Y) {
+			tp.menu.Hide()
+		} else {
+			tp.menu.OnMouseButtonEvent(event)
+		}
+		return false
+	}
+	if event.State == sdl.RELEASED {
+		if tp.drag != nil || tp.dragTrack != nil || tp.panning || tp.selecting {
+			tp.drag = nil
+			tp.dragTrack = nil
+			tp.panning = false
+			tp.selecting = false
+			return false
+		}
+		return true
+	}
+	if !tp.Pos.Contains(event.X, event.Y) {
+		return true
+	}
+	if event.Button == sdl.BUTTON_MIDDLE {
+		tp.panning = true
+		return false
+	}
+	row := tp.trackAt(event.X, event.Y)
+	if row < 0 {
+		return true
+	}
+	track := tp.tracks()[row]
+	header, clips, area := tp.rows(row)
+	switch {
+	case header.Contains(event.X, event.Y):
+		if event.Button == sdl.BUTTON_LEFT {
+			tp.current[track]++
+		} else if event.Button == sdl.BUTTON_RIGHT {
+			tp.headerMenu(event.X, event.Y, track)
+		}
+	case clips.Contains(event.X, event.Y):
+		tp.pressClips(event, track, clips)
+	case area.Contains(event.X, event.Y):
+		tp.pressLane(event, track, area)
+	}
+	return false
+}
+
+// pressClips starts dragging a clip, or sets the cursor between clips
+// and starts selecting from there
+func (tp *TrackPane) pressClips(event *sdl.MouseButtonEvent, track *Node, area sdl.Rect) {
+	i, mode := tp.clipAt(area, track, event.X, event.Y)
+	t := tp.toTime(area, event.X)
+	switch event.Button {
+	case sdl.BUTTON_LEFT:
+		if i < 0 || (sdl.GetModState() & sdl.KMOD_SHIFT) != 0 {
+			tp.cursor = t
+			tp.selection = Region{t, t}
+			tp.selecting, tp.dragArea = true, area
+			return
+		}
+		// a click on a clip places the cursor too, for splitting it
+		tp.cursor = t
+		tp.dragTrack, tp.dragClip, tp.dragMode, tp.dragArea = track, i, mode, area
+		tp.dragGrab = t - track.clips[i].Start
+	case sdl.BUTTON_RIGHT:
+		if i >= 0 {
+			tp.clipMenu(event.X, event.Y, track, i, t, tp.chapterAt(area, event.X))
+		} else {
+			tp.spaceMenu(event.X, event.Y, track, t, tp.chapterAt(area, event.X))
+		}
+	}
+}
+
+// spaceMenu offers what can go at a time between clips: another clip
+// or a chapter, or changes to the chapter that is there
+func (tp *TrackPane) spaceMenu(x, y int32, track *Node, t float64, chapter int) {
+	entries := append([]string{"add clip..."}, chapterEntries(chapter)...)
+	tp.showMenu(x, y, entries, func(entry string) {
+		if entry == "add clip..." {
+			tp.AddClip(track, t)
+		} else {
+			tp.chapterEntry(entry, t, chapter)
+		}
+	})
+}
+
+// chapterEntries are the menu entries for adding a chapter, and for
+// changing the one clicked on if there is one
+func chapterEntries(chapter int) []string {
+	entries := []string{"add chapter..."}
+	if chapter >= 0 {
+		entries = append(entries, "rename chapter...", "delete chapter")
+	}
+	return entries
+}
+
+// chapterEntry does what a chapter entry of a menu says, adding a
+// chapter at time t, and is false for any other entry
+func (tp *TrackPane) chapterEntry(entry string, t float64, chapter int) bool {
+	switch entry {
+	case "add chapter...", "add chapter at cursor...":
+		tp.canvas.askText("chapter title", fmt.Sprintf("Chapter %d", len(tp.canvas.chapters) + 1), func(title string) {
+			tp.canvas.AddChapter(t, title)
+		})
+	case "rename chapter...":
+		tp.canvas.askText("chapter title", tp.canvas.chapters[chapter].Title, func(title string) {
+			tp.canvas.chapters[chapter].Title = title
+		})
+	case "delete chapter":
+		tp.canvas.chapters = append(tp.canvas.chapters[:chapter], tp.canvas.chapters[chapter + 1:]...)
+	default:
+		return false
+	}
+	return true
+}
+
+// pressLane adds, drags or removes a breakpoint
+func (tp *TrackPane) pressLane(event *sdl.MouseButtonEvent, track *Node, area sdl.Rect) {
+	l, ok := tp.laneOf(track)
+	if !ok {
+		return
+	}
+	env := append(Envelope(nil), l.param.Envelope()...)
+	i := tp.pointAt(area, l.param, event.X, event.Y)
+	switch event.Button {
+	case sdl.BUTTON_LEFT:
+		if i < 0 {
+			b := Breakpoint{tp.toTime(area, event.X), toValue(area, l.param, event.Y)}
+			i = len(env)
+			for j, e := range env {
+				if e.Time > b.Time {
+					i = j
+					break
+				}
+			}
+			env = append(env[:i], append(Envelope{b}, env[i:]...)...)
+			l.param.SetEnvelope(env)
+		}
+		tp.drag, tp.dragIndex, tp.dragLane = l.param, i, area
+	case sdl.BUTTON_RIGHT:
+		if i >= 0 {
+			l.param.SetEnvelope(append(env[:i], env[i + 1:]...))
+		}
+	}
+}
+
+func (tp *TrackPane) OnMouseMotionEvent(event *sdl.MouseMotionEvent) bool {
+	if tp.menu.Visible {
+		tp.menu.OnMouseMotionEvent(event)
+		return false
+	}
+	if tp.panning {
+		tp.scroll = math.Max(0, tp.scroll - float64(event.XRel) / tp.zoom)
+		return false
+	}
+	if tp.dragTrack != nil {
+		tp.dragClipTo(tp.toTime(tp.dragArea, event.X))
+		return false
+	}
+	if tp.selecting {
+		t := tp.toTime(tp.dragArea, event.X)
+		tp.selection = Region{math.Min(tp.cursor, t), math.Max(tp.cursor, t)}
+		return false
+	}
+	if tp.drag == nil {
+		return true
+	}
+	env := append(Envelope(nil), tp.drag.Envelope()...)
+	if tp.dragIndex >= len(env) {
+		tp.drag = nil
+		return true
+	}
+	// a point can't be dragged past its neighbours
+	t := tp.toTime(tp.dragLane, event.X)
+	if tp.dragIndex > 0 {
+		t = math.Max(t, env[tp.dragIndex - 1].Time)
+	}
+	if tp.dragIndex < len(env) - 1 {
+		t = math.Min(t, env[tp.dragIndex + 1].Time)
+	}
+	env[tp.dragIndex] = Breakpoint{t, toValue(tp.dragLane, tp.drag, event.Y)}
+	tp.drag.SetEnvelope(env)
+	return false
+}
+
+// dragging is true while a clip is being dragged
+func (tp *TrackPane) dragging() bool {
+	return tp.dragTrack != nil
+}
+
+// dragClipTo applies a clip drag with the mouse at time t
+func (tp *TrackPane) dragClipTo(t float64) {
+	if tp.dragClip >= len(tp.dragTrack.clips) {
+		tp.dragTrack = nil
+		return
+	}
+	c := &tp.dragTrack.clips[tp.dragClip]
+	switch tp.dragMode {
+	case CLIP_MOVE:
+		c.Start = math.Max(0, t - tp.dragGrab)
+	case CLIP_TRIM_START:
+		c.TrimStart(math.Max(0, t))
+	case CLIP_TRIM_END:
+		c.TrimEnd(t)
+	case CLIP_FADE_IN:
+		c.SetFades(t - c.Start, c.FadeOut)
+	case CLIP_FADE_OUT:
+		c.FadeOut = math.Max(0, c.End() - t)
+		c.clampFades()
+	}
+}
+
+// OnKeyboardEvent nudges the track under the mouse with the arrow keys
+func (tp *TrackPane) OnKeyboardEvent(event *sdl.KeyboardEvent) bool {
+	if event.State != sdl.PRESSED {
+		return true
+	}
+	switch event.Keysym.Keycode {
+	case sdl.K_LEFT, sdl.K_RIGHT:
+		_, x, y := sdl.GetMouseState()
+		if i := tp.trackAt(int32(x), int32(y)); i >= 0 {
+			tp.canvas.Nudge(tp.tracks()[i], nudgeStep(event.Keysym.Keycode))
+			return false
+		}
+	}
+	return true
+}
+
+// OnMouseWheelEvent zooms the timeline around the mouse
+func (tp *TrackPane) OnMouseWheelEvent(event *sdl.MouseWheelEvent) bool {
+	_, x, y := sdl.GetMouseState()
+	mx, my := int32(x), int32(y)
+	if !tp.Pos.Contains(mx, my) {
+		return true
+	}
+	area := tp.timeline()
+	at := tp.toTime(area, mx)
+	tp.zoom = math.Max(0.5, math.Min(2000, tp.zoom * math.Pow(ZOOM_STEP, float64(event.Y))))
+	tp.scroll = math.Max(0, at - float64(mx - area.X) / tp.zoom)
+	return false
+}
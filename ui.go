@@ -113,6 +113,72 @@ func (pane *Pane) Destroy() {
 	}
 }
 
+// View is a pane that fills the screen below the top bar
+type View interface {
+	Visual
+	Layout
+	MouseLover
+}
+
+// Views shows one of several views in the same space, and passes
+// input on to the one showing
+type Views struct {
+	Widget
+	views []View
+	current int
+}
+
+func (v *Views) Add(view View) {
+	v.views = append(v.views, view)
+}
+
+func (v *Views) Show(i int) {
+	v.current = i
+}
+
+func (v *Views) Current() View {
+	return v.views[v.current]
+}
+
+func (v *Views) Draw(rend *sdl.Renderer) {
+	v.Current().Draw(rend)
+}
+
+func (v *Views) Destroy() {
+	for _, view := range v.views {
+		view.Destroy()
+	}
+}
+
+func (v *Views) UpdateLayout(space sdl.Rect) {
+	v.Pos = space
+	for _, view := range v.views {
+		view.UpdateLayout(space)
+	}
+}
+
+func (v *Views) OnMouseButtonEvent(event *sdl.MouseButtonEvent) bool {
+	return v.Current().OnMouseButtonEvent(event)
+}
+
+func (v *Views) OnMouseMotionEvent(event *sdl.MouseMotionEvent) bool {
+	return v.Current().OnMouseMotionEvent(event)
+}
+
+func (v *Views) OnMouseWheelEvent(event *sdl.MouseWheelEvent) bool {
+	if w, ok := v.Current().(WheelLover); ok {
+		return w.OnMouseWheelEvent(event)
+	}
+	return true
+}
+
+func (v *Views) OnKeyboardEvent(event *sdl.KeyboardEvent) bool {
+	if k, ok := v.Current().(KeyLover); ok {
+		return k.OnKeyboardEvent(event)
+	}
+	return true
+}
+
 func (h *HorizontalLayout) Init(space sdl.Rect) {
 	h.Pos = space
 	h.HSpacing = 2
@@ -258,6 +324,14 @@ func (label *Label) Draw(rend *sdl.Renderer) {
 	rend.Copy(label.texture, nil, &pos)
 }
 
+// DrawScaled draws the label with its text scaled by the given factor
+func (label *Label) DrawScaled(rend *sdl.Renderer, scale float64) {
+	w := int32(float64(label.texwidth) * scale)
+	h := int32(float64(label.texheight) * scale)
+	pos := sdl.Rect{label.Pos.X + (label.Pos.W - w) / 2, label.Pos.Y + (label.Pos.H - h)/2, w, h}
+	rend.Copy(label.texture, nil, &pos)
+}
+
 // Init a popup menu
 func (menu *PopupMenu) Init(rend *sdl.Renderer, space sdl.Rect, entries []string, font *ttf.Font) {
 	menu.Pos = space
@@ -292,6 +366,15 @@ func (menu *PopupMenu) Init(rend *sdl.Renderer, space sdl.Rect, entries []string
 	}
 }
 
+// Destroy the menu entries, so the menu can be initialized again
+func (menu *PopupMenu) Destroy() {
+	for _, e := range menu.entries {
+		e.label.Destroy()
+	}
+	menu.entries = nil
+	menu.Hide()
+}
+
 func (menu *PopupMenu) OnClick(handler func(entry *MenuEntry)) {
 	menu.clickhandler = handler
 }
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"math"
+
 	"github.com/krig/Go-SDL2/sdl"
 )
 
@@ -33,4 +35,25 @@ func darken(clr sdl.Color, ye int) sdl.Color {
 		uint8_clamp(int(clr.G) - ye),
 		uint8_clamp(int(clr.B) - ye),
 		uint8_clamp(int(clr.A) - ye)}
-}
\ No newline at end of file
+}
+
+// blend mixes t of color b into color a
+func blend(a, b sdl.Color, t float64) sdl.Color {
+	mix := func(x, y uint8) uint8 {
+		return uint8(float64(x) + (float64(y) - float64(x)) * t)
+	}
+	return sdl.Color{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), mix(a.A, b.A)}
+}
+
+// dbToGain converts decibels to a linear gain factor
+func dbToGain(db float64) float64 {
+	return math.Pow(10, db / 20)
+}
+
+// gainToDb converts a linear gain factor to decibels
+func gainToDb(gain float64) float64 {
+	if gain <= 0 {
+		return math.Inf(-1)
+	}
+	return 20 * math.Log10(gain)
+}